@@ -27,7 +27,7 @@ fi
 
 # Build the application
 echo "Building GoBI..."
-go build -o gobi cmd/gobi/main.go
+go build -o gobi ./cmd/gobi
 
 if [ $? -eq 0 ]; then
     echo "Build successful. Starting GoBI on port $PORT..."
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"GoBI/internal/database"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	yamlv3 "go.yaml.in/yaml/v3"
+)
+
+// The stanza types mirror config.Report, leaving out what introspection
+// can't know.
+type reportStanza struct {
+	ID           string         `yaml:"id"`
+	Title        string         `yaml:"title"`
+	TableName    string         `yaml:"table_name"`
+	Schema       string         `yaml:"schema"`
+	ViewType     string         `yaml:"view_type"`
+	ParentReport string         `yaml:"parent_report,omitempty"`
+	ParentColumn string         `yaml:"parent_column,omitempty"`
+	Columns      []columnStanza `yaml:"columns"`
+}
+
+type columnStanza struct {
+	Name       string `yaml:"name"`
+	Label      string `yaml:"label"`
+	Type       string `yaml:"type"`
+	Filterable bool   `yaml:"filterable,omitempty"`
+	Sortable   bool   `yaml:"sortable,omitempty"`
+}
+
+// runIntrospect implements "gobi introspect": it prints repository report
+// stanzas for the tables of a schema. It returns the process exit code.
+func runIntrospect(args []string) int {
+	flags := flag.NewFlagSet("introspect", flag.ExitOnError)
+	schema := flags.String("schema", "", "schema to read (default: the configured schema)")
+	table := flags.String("table", "%", "LIKE pattern of the table names")
+	flags.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cfg, db, err := openDatabase(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "introspect: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+	if *schema == "" {
+		*schema = cfg.Database.Schema
+	}
+
+	tables, err := database.DescribeTables(ctx, db, *schema, *table)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "introspect: %v\n", err)
+		return 1
+	}
+	if len(tables) == 0 {
+		fmt.Fprintf(os.Stderr, "introspect: no table in %s matches %q\n", *schema, *table)
+		return 1
+	}
+
+	out := yamlv3.NewEncoder(os.Stdout)
+	out.SetIndent(2)
+	defer out.Close()
+	if err := out.Encode(map[string][]reportStanza{"reports": reportStanzas(*schema, tables)}); err != nil {
+		fmt.Fprintf(os.Stderr, "introspect: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// reportStanzas turns tables into reports. A table referenced by a foreign
+// key of another becomes an aggregate with the referencing table as its
+// detail; the drill-down needs both tables in schema and the same column
+// name on both sides.
+func reportStanzas(schema string, tables []database.TableInfo) []reportStanza {
+	found := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		found[t.Name] = true
+	}
+	parents := make(map[string]bool)
+	links := make(map[string]database.ForeignKey)
+	for _, t := range tables {
+		for _, fk := range t.ForeignKeys {
+			if fk.RefSchema != schema {
+				fmt.Fprintf(os.Stderr, "# %s.%s.%s -> %s.%s: other schema, no drill-down generated\n", schema, t.Name, fk.Column, fk.RefName(), fk.RefColumn)
+				continue
+			}
+			if !found[fk.RefTable] || fk.RefTable == t.Name {
+				continue
+			}
+			if fk.Column != fk.RefColumn {
+				fmt.Fprintf(os.Stderr, "# %s.%s.%s -> %s.%s: column names differ, no drill-down generated\n", schema, t.Name, fk.Column, fk.RefName(), fk.RefColumn)
+				continue
+			}
+			if _, linked := links[t.Name]; !linked {
+				links[t.Name] = fk
+				parents[fk.RefTable] = true
+			}
+		}
+	}
+
+	reports := make([]reportStanza, 0, len(tables))
+	for _, t := range tables {
+		report := reportStanza{
+			ID:        t.Name,
+			Title:     t.Comment,
+			TableName: t.Name,
+			Schema:    schema,
+			ViewType:  "detail",
+		}
+		if report.Title == "" {
+			report.Title = labelFor(t.Name)
+		}
+		if parents[t.Name] {
+			report.ViewType = "aggregate"
+		}
+		if fk, ok := links[t.Name]; ok {
+			report.ParentReport = fk.RefTable
+			report.ParentColumn = fk.Column
+		}
+		for _, col := range t.Columns {
+			colType := columnType(col.DataType)
+			label := col.Comment
+			if label == "" {
+				label = labelFor(col.Name)
+			}
+			report.Columns = append(report.Columns, columnStanza{
+				Name:       col.Name,
+				Label:      label,
+				Type:       colType,
+				Filterable: colType != "bool",
+				Sortable:   true,
+			})
+		}
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// columnType maps a Postgres type to a repository column type.
+func columnType(dataType string) string {
+	switch {
+	case dataType == "smallint" || dataType == "integer" || dataType == "bigint":
+		return "int"
+	case strings.HasPrefix(dataType, "numeric") || dataType == "real" || dataType == "double precision":
+		return "number"
+	case dataType == "date":
+		return "date"
+	case strings.HasPrefix(dataType, "timestamp"):
+		return "timestamp"
+	case dataType == "boolean":
+		return "bool"
+	}
+	return "string"
+}
+
+// labelFor makes a label from a snake_case name.
+func labelFor(name string) string {
+	label := []rune(strings.ReplaceAll(name, "_", " "))
+	if len(label) > 0 {
+		label[0] = unicode.ToUpper(label[0])
+	}
+	return string(label)
+}
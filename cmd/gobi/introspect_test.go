@@ -0,0 +1,31 @@
+package main
+
+import (
+	"GoBI/internal/database"
+	"testing"
+)
+
+// A foreign key to a table of the same name in another schema is no
+// drill-down.
+func TestReportStanzasSchemas(t *testing.T) {
+	tables := []database.TableInfo{
+		{Name: "orders", Columns: []database.ColumnInfo{{Name: "id"}}},
+		{Name: "items", Columns: []database.ColumnInfo{{Name: "id"}, {Name: "order_id"}}, ForeignKeys: []database.ForeignKey{
+			{Column: "id", RefSchema: "archive", RefTable: "orders", RefColumn: "id"},
+		}},
+		{Name: "lines", Columns: []database.ColumnInfo{{Name: "id"}}, ForeignKeys: []database.ForeignKey{
+			{Column: "id", RefSchema: "sales", RefTable: "orders", RefColumn: "id"},
+		}},
+	}
+	reports := reportStanzas("sales", tables)
+	got := make(map[string]string)
+	for _, r := range reports {
+		got[r.ID] = r.ViewType + " " + r.ParentReport
+	}
+	want := map[string]string{"orders": "aggregate ", "items": "detail ", "lines": "detail orders"}
+	for id, w := range want {
+		if got[id] != w {
+			t.Errorf("%s: %q, want %q", id, got[id], w)
+		}
+	}
+}
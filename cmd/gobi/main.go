@@ -1,16 +1,31 @@
 package main
 
 import (
+	"GoBI/internal/auth"
 	"GoBI/internal/config"
 	"GoBI/internal/database"
 	"GoBI/internal/handlers"
 	"context"
 	"log"
 	"net/http"
+	"os"
 	"time"
 )
 
+const repositoryPath = "ui/repository.yaml"
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "introspect":
+			os.Exit(runIntrospect(os.Args[2:]))
+		case "user":
+			os.Exit(runUser(os.Args[2:]))
+		}
+	}
+
 	cfg, err := config.LoadConfig()
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
@@ -31,13 +46,33 @@ func main() {
 	}
 	log.Printf("Database health check successful.")
 
-	// Load Repository Metadata
-	repo, err := config.LoadRepository("ui/repository.yaml")
+	if err := database.CreateSavedViews(ctx, pool.GetDB()); err != nil {
+		log.Printf("Warning: Saved views are not available: %v", err)
+	}
+
+	// Load Repository Metadata, and reload it whenever it changes
+	repo, err := config.LoadRepository(repositoryPath)
+	if err == nil {
+		err = repo.Validate()
+	}
 	if err != nil {
 		log.Printf("Warning: Failed to load repository: %v", err)
+		handlers.SetRepositoryError(err)
 	} else {
 		handlers.SetRepository(repo)
 	}
+	err = config.WatchRepository(repositoryPath, func(repo *config.Repository, err error) {
+		if err != nil {
+			log.Printf("Repository reload failed, keeping the previous version: %v", err)
+			handlers.SetRepositoryError(err)
+			return
+		}
+		log.Printf("Repository reloaded: %d reports", len(repo.Reports))
+		handlers.SetRepository(repo)
+	})
+	if err != nil {
+		log.Printf("Warning: Repository changes won't be reloaded: %v", err)
+	}
 
 	handlers.SetPool(pool)
 	handlers.SetDatabaseName(cfg.Database.Database)
@@ -45,10 +80,33 @@ func main() {
 	http.HandleFunc("/", handlers.DashboardHandler)
 	http.HandleFunc("/reports", handlers.ReportsHandler)
 	http.HandleFunc("/report", handlers.ReportDetailHandler)
+	http.HandleFunc("/report/export", handlers.ExportHandler)
+	http.HandleFunc("POST /report/cancel", handlers.CancelHandler)
+	http.HandleFunc("GET /api/v1/reports", handlers.APIReportsHandler)
+	http.HandleFunc("GET /api/v1/reports/{id}", handlers.APIReportHandler)
+	http.HandleFunc("GET /api/v1/reports/{id}/data", handlers.APIReportDataHandler)
+	http.HandleFunc("GET /api/v1/reports/{id}/views", handlers.APIViewsHandler)
+	http.HandleFunc("POST /api/v1/reports/{id}/views", handlers.APISaveViewHandler)
+	http.HandleFunc("GET /api/v1/views/{viewID}", handlers.APIViewHandler)
+	http.HandleFunc("PUT /api/v1/views/{viewID}/shared", handlers.APIShareViewHandler)
+	http.HandleFunc("DELETE /api/v1/views/{viewID}", handlers.APIDeleteViewHandler)
 	http.Handle("/ui/", http.StripPrefix("/ui/", http.FileServer(http.Dir("ui"))))
 
+	// Every request but the login pages needs a signed-in user
+	var handler http.Handler = http.DefaultServeMux
+	if cfg.Auth.Enabled {
+		authn, err := auth.New(context.Background(), cfg.Auth, pool.GetDB())
+		if err != nil {
+			log.Fatalf("Failed to set up authentication: %v", err)
+		}
+		authn.Routes(http.DefaultServeMux)
+		handler = authn.Middleware(handler)
+	} else {
+		log.Printf("Warning: Authentication is disabled, every report is public")
+	}
+
 	log.Printf("GoBI Server starting on :%s", cfg.Server.Port)
-	if err := http.ListenAndServe(":"+cfg.Server.Port, nil); err != nil {
+	if err := http.ListenAndServe(":"+cfg.Server.Port, handler); err != nil {
 		log.Fatal(err)
 	}
 }
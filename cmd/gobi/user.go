@@ -0,0 +1,75 @@
+package main
+
+import (
+	"GoBI/internal/auth"
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// runUser implements "gobi user": it creates or updates a local user, reading
+// the password from the first line of stdin, or disables one. It returns the
+// process exit code.
+func runUser(args []string) int {
+	flags := flag.NewFlagSet("user", flag.ExitOnError)
+	roles := flags.String("roles", "", "comma separated roles of the user")
+	display := flags.String("display", "", "display name of the user")
+	disable := flags.Bool("disable", false, "disable the user instead")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gobi user [-roles r1,r2] [-display name] [-disable] <name> < password")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	name := flags.Arg(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, db, err := openDatabase(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "user: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+	users := auth.NewLocalUsers(db)
+	if err := users.CreateTable(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "user: %v\n", err)
+		return 1
+	}
+
+	if *disable {
+		if err := users.DisableUser(ctx, name); err != nil {
+			fmt.Fprintf(os.Stderr, "user: %s: %v\n", name, err)
+			return 1
+		}
+		fmt.Printf("%s disabled\n", name)
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "Password for %s: ", name)
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		fmt.Fprintln(os.Stderr, "\nuser: no password given")
+		return 1
+	}
+	var roleList []string
+	for _, r := range strings.Split(*roles, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			roleList = append(roleList, r)
+		}
+	}
+	if err := users.SetUser(ctx, name, *display, password, roleList); err != nil {
+		fmt.Fprintf(os.Stderr, "user: %s: %v\n", name, err)
+		return 1
+	}
+	fmt.Printf("%s saved\n", name)
+	return 0
+}
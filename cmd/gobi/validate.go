@@ -0,0 +1,122 @@
+package main
+
+import (
+	"GoBI/internal/config"
+	"GoBI/internal/database"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// runValidate implements "gobi validate": it checks the repository file and,
+// with -db, its tables and columns against the configured database. It
+// returns the process exit code.
+func runValidate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	path := flags.String("repository", repositoryPath, "repository file to check")
+	withDB := flags.Bool("db", false, "check that tables and columns exist in the database")
+	flags.Parse(args)
+
+	repo, err := config.LoadRepository(*path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *path, err)
+		return 1
+	}
+
+	problems := repo.Problems()
+	if *withDB {
+		dbProblems, err := checkDatabase(repo)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "database check failed: %v\n", err)
+			return 1
+		}
+		problems = append(problems, dbProblems...)
+	}
+
+	for _, p := range problems {
+		fmt.Fprintln(os.Stderr, p)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "%d problem(s) found\n", len(problems))
+		return 1
+	}
+	fmt.Printf("%s: %d reports OK\n", *path, len(repo.Reports))
+	return 0
+}
+
+// checkDatabase checks that the tables of table reports exist, that SQL
+// reports run with their default parameters, and that every declared column
+// is in the result.
+func checkDatabase(repo *config.Repository) ([]config.ValidationError, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cfg, db, err := openDatabase(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var problems []config.ValidationError
+	for i, report := range repo.Reports {
+		var cols []database.ColumnInfo
+		if report.SQL == "" {
+			schema := report.Schema
+			if schema == "" {
+				schema = cfg.Database.Schema
+			}
+			cols, err = database.TableColumns(ctx, db, schema, report.TableName)
+			if err != nil {
+				return nil, err
+			}
+			if len(cols) == 0 {
+				problems = append(problems, repo.ReportProblem(i, fmt.Sprintf("table %s.%s does not exist", schema, report.TableName), "table_name"))
+				continue
+			}
+		} else {
+			input := make(map[string]interface{})
+			for _, p := range report.Parameters {
+				if p.Default != "" {
+					input[p.Name] = p.Default
+				}
+			}
+			var b database.Binder
+			query := database.ProcessSQLBind(report.SQL, input, &b)
+			cols, err = database.QueryColumns(ctx, db, query, b.Args)
+			if err != nil {
+				problems = append(problems, repo.ReportProblem(i, fmt.Sprintf("sql does not run: %v", err), "sql_file"))
+				continue
+			}
+		}
+
+		names := make(map[string]bool, len(cols))
+		for _, col := range cols {
+			names[col.Name] = true
+		}
+		for j, col := range report.Columns {
+			if !names[col.Name] {
+				problems = append(problems, repo.ReportProblem(i, fmt.Sprintf("column %q is not in the result", col.Name), "columns", j, "name"))
+			}
+		}
+	}
+	return problems, nil
+}
+
+// openDatabase connects to the configured database for the subcommands.
+func openDatabase(ctx context.Context) (*config.Config, *sql.DB, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := sql.Open("postgres", cfg.Database.GetConnectStr())
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return cfg, db, nil
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrBadCredentials is returned for an unknown user, a wrong password or a
+// disabled account alike.
+var ErrBadCredentials = errors.New("invalid user name or password")
+
+// localUsersDDL creates the GoBI metadata table of local users.
+const localUsersDDL = `CREATE TABLE IF NOT EXISTS gobi_user (
+	name          text PRIMARY KEY,
+	display_name  text NOT NULL DEFAULT '',
+	password_hash text NOT NULL,
+	roles         text[] NOT NULL DEFAULT '{}',
+	disabled      boolean NOT NULL DEFAULT false,
+	created_at    timestamptz NOT NULL DEFAULT now()
+)`
+
+// dummyHash is compared against when the user doesn't exist, so that unknown
+// names take as long as wrong passwords.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("gobi"), bcrypt.DefaultCost)
+
+// LocalUsers checks passwords against the gobi_user table.
+type LocalUsers struct {
+	db *sql.DB
+}
+
+func NewLocalUsers(db *sql.DB) *LocalUsers {
+	return &LocalUsers{db: db}
+}
+
+// CreateTable creates the users table if it is missing.
+func (l *LocalUsers) CreateTable(ctx context.Context) error {
+	_, err := l.db.ExecContext(ctx, localUsersDDL)
+	return err
+}
+
+// Authenticate returns the user name if password is theirs.
+func (l *LocalUsers) Authenticate(ctx context.Context, name, password string) (*User, error) {
+	u := User{Provider: "local"}
+	var hash string
+	var disabled bool
+	err := l.db.QueryRowContext(ctx,
+		`SELECT name, display_name, password_hash, roles, disabled FROM gobi_user WHERE name = $1`, name,
+	).Scan(&u.Name, &u.DisplayName, &hash, pq.Array(&u.Roles), &disabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrBadCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil || disabled {
+		return nil, ErrBadCredentials
+	}
+	return &u, nil
+}
+
+// SetUser creates or updates a local user with a new password.
+func (l *LocalUsers) SetUser(ctx context.Context, name, displayName, password string, roles []string) error {
+	if name == "" || strings.Contains(name, ":") {
+		return errors.New("user names must be non-empty and must not contain ':'")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if roles == nil {
+		roles = []string{}
+	}
+	_, err = l.db.ExecContext(ctx, `
+		INSERT INTO gobi_user (name, display_name, password_hash, roles) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (name) DO UPDATE SET
+			display_name = EXCLUDED.display_name, password_hash = EXCLUDED.password_hash,
+			roles = EXCLUDED.roles, disabled = false`,
+		name, displayName, string(hash), pq.Array(roles))
+	return err
+}
+
+// Enabled reports whether name is a local user who may sign in.
+func (l *LocalUsers) Enabled(ctx context.Context, name string) (bool, error) {
+	var enabled bool
+	err := l.db.QueryRowContext(ctx, `SELECT NOT disabled FROM gobi_user WHERE name = $1`, name).Scan(&enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return enabled, err
+}
+
+// DisableUser keeps name from signing in. Their sessions end on the next
+// recheck of the server, see userRecheck.
+func (l *LocalUsers) DisableUser(ctx context.Context, name string) error {
+	res, err := l.db.ExecContext(ctx, `UPDATE gobi_user SET disabled = true WHERE name = $1`, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("no such user")
+	}
+	return nil
+}
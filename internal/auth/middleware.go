@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"GoBI/internal/config"
+	"context"
+	"database/sql"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// authCookie holds the session token of a signed-in browser.
+const authCookie = "gobi_auth"
+
+// defaultSessionTTL is how long a sign-in lasts when not configured.
+const defaultSessionTTL = 8 * time.Hour
+
+// userRecheck is how often the sessions of local users look up whether the
+// user is still enabled. Users are disabled from the command line, which
+// can't reach the sessions of the server.
+const userRecheck = time.Minute
+
+// publicPaths are served without signing in: the login pages and the static
+// assets they use.
+var publicPaths = []string{"/login", "/logout", "/auth/oidc/", "/ui/css/", "/ui/js/"}
+
+// Authenticator signs users in with the configured methods and requires a
+// signed-in user for everything else.
+type Authenticator struct {
+	local    *LocalUsers
+	oidc     *OIDC
+	sessions *sessionStore
+}
+
+// New sets up the login methods of cfg: the local users table in db, and
+// OIDC when an issuer is configured. ctx is kept for OIDC key fetches.
+func New(ctx context.Context, cfg config.AuthConfig, db *sql.DB) (*Authenticator, error) {
+	ttl, _ := time.ParseDuration(cfg.SessionTTL)
+	if ttl <= 0 {
+		ttl = defaultSessionTTL
+	}
+	a := &Authenticator{sessions: newSessionStore(ttl)}
+	if cfg.Local {
+		a.local = NewLocalUsers(db)
+		if err := a.local.CreateTable(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.OIDC.Issuer != "" {
+		o, err := NewOIDC(ctx, cfg.OIDC)
+		if err != nil {
+			return nil, err
+		}
+		a.oidc = o
+	}
+	if a.local == nil && a.oidc == nil {
+		return nil, errors.New("authentication is enabled without a login method")
+	}
+	return a, nil
+}
+
+// Routes registers the login and logout pages on mux.
+func (a *Authenticator) Routes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /login", a.loginPage)
+	mux.HandleFunc("POST /login", a.localLogin)
+	mux.HandleFunc("POST /logout", a.logout)
+	if a.oidc != nil {
+		mux.HandleFunc("GET /auth/oidc/login", func(w http.ResponseWriter, r *http.Request) {
+			a.oidc.start(w, r, nextURL(r.URL.Query().Get("next")))
+		})
+		mux.HandleFunc("GET /auth/oidc/callback", a.oidcCallback)
+	}
+}
+
+// Middleware puts the signed-in user into the request context. Other
+// requests are sent to the login page; API and htmx requests get 401.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie(authCookie); err == nil {
+			if u := a.sessions.user(c.Value); u != nil && a.stillEnabled(r.Context(), c.Value, u) {
+				next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), u)))
+				return
+			}
+		}
+		for _, p := range publicPaths {
+			if r.URL.Path == p || strings.HasSuffix(p, "/") && strings.HasPrefix(r.URL.Path, p) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		login := "/login?next=" + url.QueryEscape(r.URL.RequestURI())
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/api/"):
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"Authentication required"}` + "\n"))
+		case r.Header.Get("HX-Request") == "true":
+			w.Header().Set("HX-Redirect", login)
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+		case r.Method == http.MethodGet || r.Method == http.MethodHead:
+			http.Redirect(w, r, login, http.StatusSeeOther)
+		default:
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+		}
+	})
+}
+
+// stillEnabled ends the session of a local user who was disabled or removed
+// since signing in. A failed lookup keeps the session until the next check.
+func (a *Authenticator) stillEnabled(ctx context.Context, token string, u *User) bool {
+	if a.local == nil || u.Provider != "local" || !a.sessions.due(token, userRecheck) {
+		return true
+	}
+	enabled, err := a.local.Enabled(ctx, u.Name)
+	if err != nil {
+		log.Printf("Checking user %s: %v", u.Name, err)
+		return true
+	}
+	if !enabled {
+		a.sessions.delete(token)
+		log.Printf("Session of disabled user %s ended", u.Name)
+	}
+	return enabled
+}
+
+// nextURL returns next if it is a path on this server, and / otherwise.
+func nextURL(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+type loginData struct {
+	Local bool
+	OIDC  bool
+	Next  string
+	Name  string
+	Error string
+	Year  int
+}
+
+func (a *Authenticator) renderLogin(w http.ResponseWriter, status int, data loginData) {
+	tmpl := template.Must(template.ParseFiles("ui/templates/login.html"))
+	data.Local = a.local != nil
+	data.OIDC = a.oidc != nil
+	data.Year = time.Now().Year()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	tmpl.Execute(w, data)
+}
+
+func (a *Authenticator) loginPage(w http.ResponseWriter, r *http.Request) {
+	a.renderLogin(w, http.StatusOK, loginData{Next: nextURL(r.URL.Query().Get("next"))})
+}
+
+func (a *Authenticator) localLogin(w http.ResponseWriter, r *http.Request) {
+	next := nextURL(r.FormValue("next"))
+	if a.local == nil {
+		http.Error(w, "Password login is disabled", http.StatusNotFound)
+		return
+	}
+	name := r.FormValue("name")
+	u, err := a.local.Authenticate(r.Context(), name, r.FormValue("password"))
+	if errors.Is(err, ErrBadCredentials) {
+		log.Printf("Failed login for %q from %s", name, r.RemoteAddr)
+		a.renderLogin(w, http.StatusUnauthorized, loginData{Next: next, Name: name, Error: "Hibás felhasználónév vagy jelszó."})
+		return
+	}
+	if err != nil {
+		log.Printf("Login failed: %v", err)
+		a.renderLogin(w, http.StatusInternalServerError, loginData{Next: next, Name: name, Error: "A bejelentkezés nem elérhető."})
+		return
+	}
+	a.signIn(w, r, u, next)
+}
+
+func (a *Authenticator) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	u, next, err := a.oidc.finish(w, r)
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		a.renderLogin(w, http.StatusUnauthorized, loginData{Next: "/", Error: "A bejelentkezés sikertelen, próbálja újra."})
+		return
+	}
+	a.signIn(w, r, u, next)
+}
+
+// signIn starts a session for u with a new token and sends the browser on.
+func (a *Authenticator) signIn(w http.ResponseWriter, r *http.Request, u *User, next string) {
+	if c, err := r.Cookie(authCookie); err == nil {
+		a.sessions.delete(c.Value)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     authCookie,
+		Value:    a.sessions.create(u),
+		Path:     "/",
+		MaxAge:   int(a.sessions.ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	log.Printf("User %s signed in (%s)", u.Name, u.Provider)
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+func (a *Authenticator) logout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(authCookie); err == nil {
+		a.sessions.delete(c.Value)
+	}
+	http.SetCookie(w, &http.Cookie{Name: authCookie, Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"GoBI/internal/dbtest"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A disabled user loses the sessions they signed in with before.
+func TestDisabledUserSessionEnds(t *testing.T) {
+	var disabled atomic.Bool
+	db := dbtest.Open(func(query string, args []driver.Value) dbtest.Result {
+		return dbtest.Result{Columns: []string{"enabled"}, Rows: [][]driver.Value{{!disabled.Load()}}}
+	})
+	a := &Authenticator{local: NewLocalUsers(db.DB), sessions: newSessionStore(time.Hour)}
+	token := a.sessions.create(&User{Name: "u1", Provider: "local"})
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	get := func() int {
+		req := httptest.NewRequest("GET", "/report?id=r", nil)
+		req.AddCookie(&http.Cookie{Name: authCookie, Value: token})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	expire := func() {
+		a.sessions.mu.Lock()
+		a.sessions.m[token].checked = time.Now().Add(-2 * userRecheck)
+		a.sessions.mu.Unlock()
+	}
+
+	if code := get(); code != http.StatusOK || db.Count("SELECT") != 0 {
+		t.Fatalf("fresh session: %d after %d lookups", code, db.Count("SELECT"))
+	}
+	expire()
+	if code := get(); code != http.StatusOK || db.Count("SELECT") != 1 {
+		t.Fatalf("enabled user: %d after %d lookups", code, db.Count("SELECT"))
+	}
+
+	disabled.Store(true)
+	expire()
+	if code := get(); code != http.StatusSeeOther {
+		t.Fatalf("disabled user: %d, want a redirect to the login page", code)
+	}
+	if a.sessions.user(token) != nil {
+		t.Error("the session of the disabled user is kept")
+	}
+}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"GoBI/internal/config"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// oidcStateCookie binds a login in progress to the browser that started it.
+const oidcStateCookie = "gobi_oidc_state"
+
+// loginTTL is how long a login may stay at the identity provider.
+const loginTTL = 10 * time.Minute
+
+// OIDCPrefix starts the names of OIDC users, keeping them apart from local
+// users.
+const OIDCPrefix = "oidc:"
+
+// pendingLogin is a login redirected to the identity provider.
+type pendingLogin struct {
+	nonce    string
+	verifier string
+	next     string
+	expires  time.Time
+}
+
+// OIDC signs users in with an OpenID Connect identity provider, using the
+// authorization code flow with PKCE.
+type OIDC struct {
+	oauth       oauth2.Config
+	verifier    *oidc.IDTokenVerifier
+	groupsClaim string
+	userClaim   string
+
+	mu      sync.Mutex
+	pending map[string]*pendingLogin
+}
+
+// NewOIDC discovers the identity provider of cfg. Its signing keys are
+// fetched with ctx later on, so ctx must not be canceled.
+func NewOIDC(ctx context.Context, cfg config.OIDCConfig) (*OIDC, error) {
+	provider, err := oidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+	}
+	return &OIDC{
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       scopes,
+		},
+		verifier:    provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+		groupsClaim: cfg.GroupsClaim,
+		userClaim:   cfg.UserClaim,
+		pending:     make(map[string]*pendingLogin),
+	}, nil
+}
+
+// start redirects the browser to the identity provider; next is where the
+// user goes once signed in.
+func (o *OIDC) start(w http.ResponseWriter, r *http.Request, next string) {
+	state := NewToken()
+	login := &pendingLogin{
+		nonce:    NewToken(),
+		verifier: oauth2.GenerateVerifier(),
+		next:     next,
+		expires:  time.Now().Add(loginTTL),
+	}
+
+	o.mu.Lock()
+	for s, p := range o.pending {
+		if time.Now().After(p.expires) {
+			delete(o.pending, s)
+		}
+	}
+	o.pending[state] = login
+	o.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/auth/oidc/",
+		MaxAge:   int(loginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, o.oauth.AuthCodeURL(state, oidc.Nonce(login.nonce), oauth2.S256ChallengeOption(login.verifier)), http.StatusFound)
+}
+
+// finish completes the login the identity provider redirected back, and
+// returns the user and where they go next.
+func (o *OIDC) finish(w http.ResponseWriter, r *http.Request) (*User, string, error) {
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/auth/oidc/", MaxAge: -1})
+
+	state := r.URL.Query().Get("state")
+	c, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || c.Value != state {
+		return nil, "", errors.New("login state mismatch")
+	}
+	o.mu.Lock()
+	login, ok := o.pending[state]
+	delete(o.pending, state)
+	o.mu.Unlock()
+	if !ok || time.Now().After(login.expires) {
+		return nil, "", errors.New("login expired")
+	}
+	if msg := r.URL.Query().Get("error"); msg != "" {
+		return nil, "", fmt.Errorf("identity provider: %s %s", msg, r.URL.Query().Get("error_description"))
+	}
+
+	token, err := o.oauth.Exchange(r.Context(), r.URL.Query().Get("code"), oauth2.VerifierOption(login.verifier))
+	if err != nil {
+		return nil, "", fmt.Errorf("code exchange: %w", err)
+	}
+	rawID, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, "", errors.New("no id_token in the token response")
+	}
+	idToken, err := o.verifier.Verify(r.Context(), rawID)
+	if err != nil {
+		return nil, "", fmt.Errorf("id token: %w", err)
+	}
+	if idToken.Nonce != login.nonce {
+		return nil, "", errors.New("id token nonce mismatch")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, "", err
+	}
+	u, err := o.user(idToken.Subject, claims)
+	if err != nil {
+		return nil, "", err
+	}
+	return u, login.next, nil
+}
+
+// user maps ID token claims to a user: the configured user claim, the
+// subject by default, names it and the groups claim gives its roles.
+func (o *OIDC) user(subject string, claims map[string]interface{}) (*User, error) {
+	id := subject
+	if o.userClaim != "" && o.userClaim != "sub" {
+		id, _ = claims[o.userClaim].(string)
+		if id == "" {
+			return nil, fmt.Errorf("id token has no %s claim", o.userClaim)
+		}
+		if verified, _ := claims["email_verified"].(bool); o.userClaim == "email" && !verified {
+			return nil, errors.New("e-mail address is not verified")
+		}
+	}
+	if id == "" {
+		return nil, errors.New("id token has no subject")
+	}
+	u := &User{Name: OIDCPrefix + id, Provider: "oidc"}
+	for _, claim := range []string{"name", "preferred_username", "email"} {
+		if s, _ := claims[claim].(string); s != "" {
+			u.DisplayName = s
+			break
+		}
+	}
+	switch groups := claims[o.groupsClaim].(type) {
+	case []interface{}:
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				u.Roles = append(u.Roles, s)
+			}
+		}
+	case string:
+		u.Roles = []string{groups}
+	}
+	return u, nil
+}
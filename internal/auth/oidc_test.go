@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"GoBI/internal/config"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockIdP is an OpenID Connect provider issuing RS256 ID tokens with the
+// claims of the test for every code it is given.
+type mockIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu        sync.Mutex
+	claims    map[string]interface{}
+	nonce     string
+	challenge string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                idp.URL,
+			"authorization_endpoint":                idp.URL + "/authorize",
+			"token_endpoint":                        idp.URL + "/token",
+			"jwks_uri":                              idp.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "alg": "RS256", "use": "sig", "kid": "test",
+			"n": b64(key.N.Bytes()),
+			"e": b64(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if b64(sum[:]) != idp.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		claims := map[string]interface{}{
+			"iss": idp.URL, "aud": "gobi", "nonce": idp.nonce,
+			"iat": time.Now().Unix(), "exp": time.Now().Add(time.Minute).Unix(),
+		}
+		for k, v := range idp.claims {
+			claims[k] = v
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access", "token_type": "Bearer", "id_token": idp.sign(t, claims),
+		})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (idp *mockIdP) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64(sig)
+}
+
+// login runs the authorization code flow of o against the mock provider.
+func (idp *mockIdP) login(t *testing.T, o *OIDC, claims map[string]interface{}) (*User, string, error) {
+	rec := httptest.NewRecorder()
+	o.start(rec, httptest.NewRequest("GET", "/auth/oidc/login", nil), "/report?id=r")
+	loc, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.mu.Lock()
+	idp.claims = claims
+	idp.nonce = loc.Query().Get("nonce")
+	idp.challenge = loc.Query().Get("code_challenge")
+	idp.mu.Unlock()
+
+	state := loc.Query().Get("state")
+	req := httptest.NewRequest("GET", "/auth/oidc/callback?code=c&state="+url.QueryEscape(state), nil)
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	return o.finish(httptest.NewRecorder(), req)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp := newMockIdP(t)
+	tests := []struct {
+		name      string
+		userClaim string
+		claims    map[string]interface{}
+		want      string
+		wantErr   string
+	}{
+		{
+			name:   "subject",
+			claims: map[string]interface{}{"sub": "u1", "email": "admin", "preferred_username": "admin"},
+			want:   OIDCPrefix + "u1",
+		},
+		{
+			name:      "verified email",
+			userClaim: "email",
+			claims:    map[string]interface{}{"sub": "u1", "email": "a@example.com", "email_verified": true},
+			want:      OIDCPrefix + "a@example.com",
+		},
+		{
+			name:      "unverified email",
+			userClaim: "email",
+			claims:    map[string]interface{}{"sub": "u1", "email": "a@example.com"},
+			wantErr:   "not verified",
+		},
+		{
+			name:      "missing claim",
+			userClaim: "employee_id",
+			claims:    map[string]interface{}{"sub": "u1"},
+			wantErr:   "no employee_id claim",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := NewOIDC(context.Background(), config.OIDCConfig{
+				Issuer: idp.URL, ClientID: "gobi", RedirectURL: "http://gobi/auth/oidc/callback",
+				GroupsClaim: "groups", UserClaim: tt.userClaim,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims := map[string]interface{}{"groups": []string{"felelos"}}
+			for k, v := range tt.claims {
+				claims[k] = v
+			}
+			u, next, err := idp.login(t, o, claims)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Name != tt.want || !u.HasRole("felelos") || next != "/report?id=r" {
+				t.Errorf("user %+v, next %q", u, next)
+			}
+		})
+	}
+}
+
+func TestOIDCStateMismatch(t *testing.T) {
+	idp := newMockIdP(t)
+	o, err := NewOIDC(context.Background(), config.OIDCConfig{Issuer: idp.URL, ClientID: "gobi", GroupsClaim: "groups"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	o.start(rec, httptest.NewRequest("GET", "/auth/oidc/login", nil), "/")
+	req := httptest.NewRequest("GET", "/auth/oidc/callback?code=c&state=forged", nil)
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	if _, _, err := o.finish(httptest.NewRecorder(), req); err == nil {
+		t.Error("a forged state is accepted")
+	}
+}
+
+// Local names can't take the form of OIDC names.
+func TestLocalNameWithoutPrefix(t *testing.T) {
+	err := NewLocalUsers(nil).SetUser(context.Background(), OIDCPrefix+"u1", "", "secret", nil)
+	if err == nil {
+		t.Error("a local user is named like an OIDC user")
+	}
+}
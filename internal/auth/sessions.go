@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// session is a signed-in browser; checked is when its user was last found
+// enabled.
+type session struct {
+	user    *User
+	expires time.Time
+	checked time.Time
+}
+
+// sessionStore keeps the signed-in sessions in memory; users sign in again
+// after a restart.
+type sessionStore struct {
+	mu  sync.Mutex
+	m   map[string]*session
+	ttl time.Duration
+}
+
+func newSessionStore(ttl time.Duration) *sessionStore {
+	return &sessionStore{m: make(map[string]*session), ttl: ttl}
+}
+
+// NewToken returns a cryptographically random hex token.
+func NewToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// create signs u in and returns the session token.
+func (s *sessionStore) create(u *User) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for token, sess := range s.m {
+		if now.After(sess.expires) {
+			delete(s.m, token)
+		}
+	}
+	token := NewToken()
+	s.m[token] = &session{user: u, expires: now.Add(s.ttl), checked: now}
+	return token
+}
+
+// user returns the user of a live session, or nil.
+func (s *sessionStore) user(token string) *User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.m[token]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(sess.expires) {
+		delete(s.m, token)
+		return nil
+	}
+	return sess.user
+}
+
+func (s *sessionStore) delete(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, token)
+}
+
+// due reports whether the user of the session was last checked more than
+// every ago, and if so counts it as checked now.
+func (s *sessionStore) due(token string, every time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.m[token]
+	if !ok || time.Since(sess.checked) < every {
+		return false
+	}
+	sess.checked = time.Now()
+	return true
+}
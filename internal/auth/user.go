@@ -0,0 +1,50 @@
+package auth
+
+import "context"
+
+// User is a signed-in user.
+type User struct {
+	// Name identifies the user. OIDC users' names start with OIDCPrefix,
+	// which local names can't contain.
+	Name        string
+	DisplayName string
+	// Roles are the roles of a local user or the groups the identity
+	// provider reports
+	Roles []string
+	// Provider is the way the user signed in: local or oidc
+	Provider string
+}
+
+// Label returns the name to show for the user.
+func (u *User) Label() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	return u.Name
+}
+
+// HasRole reports whether the user has one of roles.
+func (u *User) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		for _, have := range u.Roles {
+			if have == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type userKey struct{}
+
+// WithUser returns a context carrying u.
+func WithUser(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, userKey{}, u)
+}
+
+// UserFrom returns the user of a request context, or nil when
+// authentication is disabled.
+func UserFrom(ctx context.Context) *User {
+	u, _ := ctx.Value(userKey{}).(*User)
+	return u
+}
@@ -13,6 +13,7 @@ type Config struct {
 	Server     ServerConfig     `mapstructure:"server"`
 	Database   DatabaseConfig   `mapstructure:"database"`
 	CursorPool CursorPoolConfig `mapstructure:"cursor_pool"`
+	Auth       AuthConfig       `mapstructure:"auth"`
 }
 
 type ServerConfig struct {
@@ -36,13 +37,41 @@ func (cfg *DatabaseConfig) GetConnectStr() string {
 }
 
 type CursorPoolConfig struct {
-	MaxConnections     int    `mapstructure:"max_connections"`
+	// MaxConnections caps the open cursors, 0 for no limit
+	MaxConnections int `mapstructure:"max_connections"`
+	// QueryConnections are the connections beside the cursors' for one-time
+	// queries, totals, exports and metadata; the database connections are
+	// capped at their sum when MaxConnections is set
+	QueryConnections   int    `mapstructure:"query_connections"`
 	IdleTimeout        string `mapstructure:"idle_timeout"`
 	AbsoluteTimeout    string `mapstructure:"absolute_timeout"`
 	PageSize           int    `mapstructure:"page_size"`
 	AvailablePageSizes []int  `mapstructure:"available_page_sizes"`
 }
 
+type AuthConfig struct {
+	// Enabled requires users to sign in; Local enables the users table
+	Enabled    bool       `mapstructure:"enabled"`
+	Local      bool       `mapstructure:"local"`
+	SessionTTL string     `mapstructure:"session_ttl"`
+	OIDC       OIDCConfig `mapstructure:"oidc"`
+}
+
+// OIDCConfig enables OIDC login when Issuer is set.
+type OIDCConfig struct {
+	Issuer       string   `mapstructure:"issuer"`
+	ClientID     string   `mapstructure:"client_id"`
+	ClientSecret string   `mapstructure:"client_secret"`
+	RedirectURL  string   `mapstructure:"redirect_url"`
+	Scopes       []string `mapstructure:"scopes"`
+	// GroupsClaim names the ID token claim holding the user's groups
+	GroupsClaim string `mapstructure:"groups_claim"`
+	// UserClaim names the ID token claim identifying the user, sub by
+	// default. It must be stable and unique at the issuer; email is only
+	// accepted when email_verified is set.
+	UserClaim string `mapstructure:"user_claim"`
+}
+
 func LoadConfig() (*Config, error) {
 	// Load .env file if it exists
 	if err := godotenv.Load(); err != nil {
@@ -74,9 +103,18 @@ func LoadConfig() (*Config, error) {
 	if cfg.Database.Port == "" {
 		cfg.Database.Port = "5432"
 	}
+	if cfg.CursorPool.QueryConnections <= 0 {
+		cfg.CursorPool.QueryConnections = 10
+	}
 	if cfg.CursorPool.PageSize == 0 {
 		cfg.CursorPool.PageSize = 10
 	}
+	if cfg.Auth.OIDC.GroupsClaim == "" {
+		cfg.Auth.OIDC.GroupsClaim = "groups"
+	}
+	if cfg.Auth.OIDC.UserClaim == "" {
+		cfg.Auth.OIDC.UserClaim = "sub"
+	}
 
 	return &cfg, nil
 }
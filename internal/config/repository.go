@@ -1,7 +1,10 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/go-yaml/yaml"
 )
@@ -9,45 +12,201 @@ import (
 type Repository struct {
 	Meta    Meta     `yaml:"repository"`
 	Reports []Report `yaml:"reports"`
+	// file is the path the repository was loaded from
+	file string
 }
 
 type Meta struct {
-	Name        string `yaml:"name"`
-	Version     string `yaml:"version"`
-	Description string `yaml:"description"`
+	Name        string `yaml:"name" json:"name"`
+	Version     string `yaml:"version" json:"version"`
+	Description string `yaml:"description" json:"description"`
 }
 
 type Report struct {
-	ID           string   `yaml:"id"`
-	Title        string   `yaml:"title"`
-	Description  string   `yaml:"description"`
-	TableName    string   `yaml:"table_name"`
-	Schema       string   `yaml:"schema"`
-	ViewType     string   `yaml:"view_type"`
-	ParentReport string   `yaml:"parent_report"`
-	ParentColumn string   `yaml:"parent_column"`
-	Columns      []Column `yaml:"columns"`
+	ID           string `yaml:"id"`
+	Title        string `yaml:"title"`
+	Description  string `yaml:"description"`
+	TableName    string `yaml:"table_name"`
+	Schema       string `yaml:"schema"`
+	SQLFile      string `yaml:"sql_file"`
+	SQL          string `yaml:"sql"`
+	ViewType     string `yaml:"view_type"`
+	ParentReport string `yaml:"parent_report"`
+	ParentColumn string `yaml:"parent_column"`
+	// ParentColumns maps several parent columns to child columns, replacing
+	// ParentColumn
+	ParentColumns []ColumnLink `yaml:"parent_columns"`
+	Columns       []Column     `yaml:"columns"`
+	Parameters    []Parameter  `yaml:"parameters"`
+	Pivot         *Pivot       `yaml:"pivot"`
+	// RowCount is how cursor-backed reports count their rows: "move" (default)
+	// moves the cursor to its end, "count" runs a parallel count(*), "none"
+	// only learns the count when paging reaches the end
+	RowCount string `yaml:"row_count"`
+	// KeyColumn is a unique column that turns on keyset paging of a detail
+	// report
+	KeyColumn string `yaml:"key_column"`
+	// KeyColumns are columns unique together, replacing KeyColumn
+	KeyColumns []string `yaml:"key_columns"`
+	// Timeout limits the report's queries, as a Go duration like "30s"
+	Timeout string `yaml:"timeout"`
+	// Roles limits the report to users with one of these roles or groups;
+	// empty allows every user
+	Roles []string `yaml:"roles"`
+	// RowFilters restrict the rows users see by role
+	RowFilters []RowFilter `yaml:"row_filters"`
+}
+
+// SQL template parameters set from the signed-in user, never from the
+// request.
+const (
+	CurrentUserParam  = "current_user"
+	CurrentRolesParam = "current_roles"
+)
+
+// Request parameters of the report page, which report parameters must not
+// be named like. NavParams only drive paging of the current result and
+// ReservedParams shape the query outside the SQL template; ParamPrefixes
+// start the filter bar and drill-down parameters.
+var (
+	NavParams = []string{
+		"id", "dir", "session", "page_size", "page", "row", "after", "before", "limit", "offset",
+		"_from", "_back", "view",
+	}
+	ReservedParams = []string{
+		"sort", "filter_col", "filter_val", "group", "measure", "trail", "format",
+		CurrentUserParam, CurrentRolesParam,
+	}
+	ParamPrefixes = []string{"f.", "op.", "d."}
+)
+
+// IsRequestParam reports whether name is a request parameter of the report
+// page.
+func IsRequestParam(name string) bool {
+	for _, list := range [][]string{NavParams, ReservedParams} {
+		for _, p := range list {
+			if p == name {
+				return true
+			}
+		}
+	}
+	for _, prefix := range ParamPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// RowFilter restricts the rows of the users holding one of Roles, or of every
+// user when Roles is empty, to those matching the SQL condition Filter. It is
+// rendered like report SQL, with :current_user and :current_roles bound. A
+// user sees the rows matching any of their filters; a filter without a
+// condition lifts the restriction, and users without a filter see no rows.
+type RowFilter struct {
+	Roles  []string `yaml:"roles"`
+	Filter string   `yaml:"filter"`
 }
 
 type Column struct {
-	Name          string `yaml:"name"`
-	Label         string `yaml:"label"`
-	Type          string `yaml:"type"`
-	Filterable    bool   `yaml:"filterable"`
+	Name       string `yaml:"name"`
+	Label      string `yaml:"label"`
+	Type       string `yaml:"type"`
+	Filterable bool   `yaml:"filterable"`
+	// Filter "select" offers a filterable text column's distinct values as a
+	// multi-select, looking them up over the whole result; by default it is
+	// a text filter
+	Filter        string `yaml:"filter"`
 	Sortable      bool   `yaml:"sortable"`
 	AggregateFunc string `yaml:"aggregate_func"`
 	Hidden        bool   `yaml:"hidden"`
 }
 
+// Keys returns the columns identifying a row for keyset paging, none when
+// the report isn't keyset paged.
+func (r *Report) Keys() []string {
+	if len(r.KeyColumns) > 0 {
+		return r.KeyColumns
+	}
+	if r.KeyColumn != "" {
+		return []string{r.KeyColumn}
+	}
+	return nil
+}
+
+// ColumnLink maps a parent report column to the child column it filters.
+type ColumnLink struct {
+	Parent string `yaml:"parent" json:"parent"`
+	Child  string `yaml:"child" json:"child"`
+}
+
+// Links returns the columns joining the report to its parent report.
+func (r *Report) Links() []ColumnLink {
+	if len(r.ParentColumns) > 0 {
+		return r.ParentColumns
+	}
+	if r.ParentColumn != "" {
+		return []ColumnLink{{Parent: r.ParentColumn, Child: r.ParentColumn}}
+	}
+	return nil
+}
+
+// Pivot configures the cross-tab of a report with view_type "pivot". The
+// measure is aggregated with its column's aggregate_func.
+type Pivot struct {
+	Rows       []string `yaml:"rows" json:"rows"`
+	Column     string   `yaml:"column" json:"column"`
+	Measure    string   `yaml:"measure" json:"measure"`
+	MaxColumns int      `yaml:"max_columns" json:"max_columns"`
+}
+
+// Parameter is a declared input of a report's SQL template. Type is one of
+// string, int, number, date, timestamp or bool.
+type Parameter struct {
+	Name     string   `yaml:"name"`
+	Label    string   `yaml:"label"`
+	Type     string   `yaml:"type"`
+	Required bool     `yaml:"required"`
+	Default  string   `yaml:"default"`
+	Options  []Option `yaml:"options"`
+	// OptionsSQL queries the options instead. The report's row filters don't
+	// apply to it: it is rendered with :current_user and :current_roles of
+	// the signed-in user, and has to restrict its rows itself.
+	OptionsSQL string `yaml:"options_sql"`
+}
+
+type Option struct {
+	Value string `yaml:"value" json:"value"`
+	Label string `yaml:"label" json:"label"`
+}
+
 func LoadRepository(path string) (*Repository, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	var repo Repository
+	repo := Repository{file: path}
 	err = yaml.Unmarshal(data, &repo)
 	if err != nil {
 		return nil, err
 	}
+
+	// SQL files are resolved relative to the repository file
+	for i := range repo.Reports {
+		report := &repo.Reports[i]
+		if report.SQLFile != "" {
+			sqlPath := report.SQLFile
+			if !filepath.IsAbs(sqlPath) {
+				sqlPath = filepath.Join(filepath.Dir(path), sqlPath)
+			}
+			sqlText, err := os.ReadFile(sqlPath)
+			if err != nil {
+				return nil, fmt.Errorf("report %s: %w", report.ID, err)
+			}
+			report.SQL = string(sqlText)
+		}
+		// Report SQL is embedded as a subquery, so drop any trailing semicolon
+		report.SQL = strings.TrimRight(strings.TrimSpace(report.SQL), ";")
+	}
 	return &repo, nil
 }
@@ -0,0 +1,282 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	yamlv3 "go.yaml.in/yaml/v3"
+)
+
+var (
+	viewTypes      = []string{"aggregate", "detail", "pivot"}
+	valueTypes     = []string{"string", "int", "number", "date", "timestamp", "bool"}
+	aggregateFuncs = []string{"sum", "min", "max", "count", "avg", "count_distinct"}
+	rowCountModes  = []string{"move", "count", "none"}
+	filterKinds    = []string{"text", "select"}
+)
+
+// ValidationError is a repository problem, located in the repository file
+// when it was loaded from one.
+type ValidationError struct {
+	File    string
+	Line    int
+	Report  string
+	Message string
+
+	// report is the index of the report, path the keys and list indices of
+	// the offending node below it
+	report int
+	path   []interface{}
+}
+
+func (e ValidationError) Error() string {
+	msg := e.Message
+	if e.Report != "" {
+		msg = "report " + e.Report + ": " + msg
+	}
+	switch {
+	case e.File != "" && e.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, msg)
+	case e.File != "":
+		return e.File + ": " + msg
+	}
+	return msg
+}
+
+func oneOf(val string, allowed []string) bool {
+	for _, a := range allowed {
+		if val == a {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the repository for errors that would break its reports and
+// returns them joined, or nil.
+func (r *Repository) Validate() error {
+	var errs []error
+	for _, e := range r.Problems() {
+		errs = append(errs, e)
+	}
+	return errors.Join(errs...)
+}
+
+// Problems returns every problem of the repository: missing or duplicate
+// IDs, reports without a source, dangling parent references, unknown view
+// types, value types and aggregate functions, parameter names taken by the
+// report page, and empty row filters.
+func (r *Repository) Problems() []ValidationError {
+	var problems []ValidationError
+	add := func(i int, msg string, path ...interface{}) {
+		problems = append(problems, ValidationError{Report: r.Reports[i].ID, Message: msg, report: i, path: path})
+	}
+
+	ids := make(map[string]int, len(r.Reports))
+	for i, report := range r.Reports {
+		if report.ID == "" {
+			add(i, "report has no id")
+		} else if _, dup := ids[report.ID]; dup {
+			add(i, "duplicate id", "id")
+		} else {
+			ids[report.ID] = i
+		}
+		if report.TableName == "" && report.SQL == "" {
+			add(i, "neither table_name nor sql is set")
+		}
+		if report.ViewType != "" && !oneOf(report.ViewType, viewTypes) {
+			add(i, fmt.Sprintf("unknown view_type %q", report.ViewType), "view_type")
+		}
+		if report.RowCount != "" && !oneOf(report.RowCount, rowCountModes) {
+			add(i, fmt.Sprintf("unknown row_count %q", report.RowCount), "row_count")
+		}
+		if report.Timeout != "" {
+			if _, err := time.ParseDuration(report.Timeout); err != nil {
+				add(i, fmt.Sprintf("invalid timeout %q", report.Timeout), "timeout")
+			}
+		}
+
+		columns := make(map[string]bool, len(report.Columns))
+		for j, col := range report.Columns {
+			if col.Name == "" {
+				add(i, "column has no name", "columns", j)
+			} else if columns[col.Name] {
+				add(i, fmt.Sprintf("duplicate column %q", col.Name), "columns", j, "name")
+			}
+			columns[col.Name] = true
+			if col.Type != "" && !oneOf(col.Type, valueTypes) {
+				add(i, fmt.Sprintf("column %s: unknown type %q", col.Name, col.Type), "columns", j, "type")
+			}
+			if col.AggregateFunc != "" && !oneOf(col.AggregateFunc, aggregateFuncs) {
+				add(i, fmt.Sprintf("column %s: unknown aggregate_func %q", col.Name, col.AggregateFunc), "columns", j, "aggregate_func")
+			}
+			if col.Filter != "" && !oneOf(col.Filter, filterKinds) {
+				add(i, fmt.Sprintf("column %s: unknown filter %q", col.Name, col.Filter), "columns", j, "filter")
+			} else if col.Filter != "" && !col.Filterable {
+				add(i, fmt.Sprintf("column %s: filter is set but the column is not filterable", col.Name), "columns", j, "filter")
+			}
+		}
+		declared := func(name string) bool {
+			return len(report.Columns) == 0 || columns[name]
+		}
+
+		for j, p := range report.Parameters {
+			if p.Name == "" {
+				add(i, "parameter has no name", "parameters", j)
+			}
+			if p.Type != "" && !oneOf(p.Type, valueTypes) {
+				add(i, fmt.Sprintf("parameter %s: unknown type %q", p.Name, p.Type), "parameters", j, "type")
+			}
+			if p.Name == CurrentUserParam || p.Name == CurrentRolesParam {
+				add(i, fmt.Sprintf("parameter name %q is reserved for the signed-in user", p.Name), "parameters", j, "name")
+			} else if IsRequestParam(p.Name) {
+				add(i, fmt.Sprintf("parameter name %q is a request parameter of the report page", p.Name), "parameters", j, "name")
+			}
+		}
+
+		for j, f := range report.RowFilters {
+			if len(f.Roles) == 0 && strings.TrimSpace(f.Filter) == "" {
+				add(i, "row filter has neither roles nor filter", "row_filters", j)
+			}
+		}
+
+		if report.KeyColumn != "" && !declared(report.KeyColumn) {
+			add(i, fmt.Sprintf("key_column %q is not a column", report.KeyColumn), "key_column")
+		}
+		for j, key := range report.KeyColumns {
+			if !declared(key) {
+				add(i, fmt.Sprintf("key column %q is not a column", key), "key_columns", j)
+			}
+		}
+		if report.ViewType == "pivot" && report.Pivot == nil {
+			add(i, "pivot report has no pivot section", "view_type")
+		}
+		if pv := report.Pivot; pv != nil {
+			if len(pv.Rows) == 0 || pv.Column == "" || pv.Measure == "" {
+				add(i, "pivot needs rows, column and measure", "pivot")
+			}
+			for j, name := range pv.Rows {
+				if !declared(name) {
+					add(i, fmt.Sprintf("pivot row %q is not a column", name), "pivot", "rows", j)
+				}
+			}
+			if pv.Column != "" && !declared(pv.Column) {
+				add(i, fmt.Sprintf("pivot column %q is not a column", pv.Column), "pivot", "column")
+			}
+			if pv.Measure != "" && !declared(pv.Measure) {
+				add(i, fmt.Sprintf("pivot measure %q is not a column", pv.Measure), "pivot", "measure")
+			}
+		}
+	}
+
+	for i, report := range r.Reports {
+		if report.ParentReport == "" {
+			continue
+		}
+		parent, ok := ids[report.ParentReport]
+		if !ok {
+			add(i, fmt.Sprintf("parent_report %q does not exist", report.ParentReport), "parent_report")
+			continue
+		}
+		if report.ParentColumn != "" && len(report.ParentColumns) > 0 {
+			add(i, "parent_column and parent_columns are both set", "parent_columns")
+		}
+		links := report.Links()
+		if len(links) == 0 {
+			add(i, "parent_report is set without parent_column", "parent_report")
+			continue
+		}
+		has := func(cols []Column, name string) bool {
+			found := len(cols) == 0
+			for _, col := range cols {
+				found = found || col.Name == name
+			}
+			return found
+		}
+		for j, link := range links {
+			// A single parent_column names the same column on both sides
+			parentPath, childPath := []interface{}{"parent_column"}, []interface{}{"parent_column"}
+			if len(report.ParentColumns) > 0 {
+				parentPath = []interface{}{"parent_columns", j, "parent"}
+				childPath = []interface{}{"parent_columns", j, "child"}
+			}
+			if !has(r.Reports[parent].Columns, link.Parent) {
+				add(i, fmt.Sprintf("parent column %q is not a column of %s", link.Parent, report.ParentReport), parentPath...)
+			}
+			if !has(report.Columns, link.Child) {
+				add(i, fmt.Sprintf("child column %q is not a column", link.Child), childPath...)
+			}
+		}
+	}
+
+	r.locate(problems)
+	return problems
+}
+
+// ReportProblem returns a located problem of the i-th report; path names the
+// offending node below the report, as keys and list indices.
+func (r *Repository) ReportProblem(i int, msg string, path ...interface{}) ValidationError {
+	problems := []ValidationError{{Report: r.Reports[i].ID, Message: msg, report: i, path: path}}
+	r.locate(problems)
+	return problems[0]
+}
+
+// locate sets the file and line of problems from the repository file's
+// syntax tree. Problems whose node is missing get the line of the closest
+// existing parent.
+func (r *Repository) locate(problems []ValidationError) {
+	if r.file == "" || len(problems) == 0 {
+		return
+	}
+	data, err := os.ReadFile(r.file)
+	var doc yamlv3.Node
+	if err == nil {
+		err = yamlv3.Unmarshal(data, &doc)
+	}
+	var reports *yamlv3.Node
+	if err == nil && len(doc.Content) > 0 {
+		reports = child(doc.Content[0], "reports")
+	}
+
+	for i := range problems {
+		p := &problems[i]
+		p.File = r.file
+		if reports == nil || reports.Kind != yamlv3.SequenceNode || p.report >= len(reports.Content) {
+			continue
+		}
+		node := reports.Content[p.report]
+		p.Line = node.Line
+		for _, step := range p.path {
+			switch s := step.(type) {
+			case string:
+				node = child(node, s)
+			case int:
+				if node.Kind == yamlv3.SequenceNode && s < len(node.Content) {
+					node = node.Content[s]
+				} else {
+					node = nil
+				}
+			}
+			if node == nil {
+				break
+			}
+			p.Line = node.Line
+		}
+	}
+}
+
+// child returns the value node of key in a mapping node, or nil.
+func child(node *yamlv3.Node, key string) *yamlv3.Node {
+	if node == nil || node.Kind != yamlv3.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const problemsYAML = `repository:
+  name: test
+reports:
+  - id: orders
+    table_name: orders
+    view_type: cube
+    columns:
+      - name: id
+        type: int
+      - name: total
+        type: money
+  - id: items
+    table_name: items
+    parent_report: missing
+    parameters:
+      - name: sort
+`
+
+// Problems point at the offending node of the repository file, or at the
+// report when the node is missing.
+func TestProblemsLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "repository.yaml")
+	if err := os.WriteFile(path, []byte(problemsYAML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := LoadRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{
+		`unknown view_type "cube"`:                                        6,
+		`column total: unknown type "money"`:                              11,
+		`parameter name "sort" is a request parameter of the report page`: 16,
+		`parent_report "missing" does not exist`:                          14,
+	}
+	problems := repo.Problems()
+	if len(problems) != len(want) {
+		t.Errorf("%d problems, want %d: %v", len(problems), len(want), problems)
+	}
+	for _, p := range problems {
+		line, ok := want[p.Message]
+		if !ok {
+			t.Errorf("unexpected problem %v", p)
+			continue
+		}
+		if p.File != path || p.Line != line {
+			t.Errorf("%q at %s:%d, want line %d", p.Message, p.File, p.Line, line)
+		}
+	}
+
+	missing := repo.ReportProblem(1, "no such node", "pivot", "rows", 0)
+	if missing.Line != 12 {
+		t.Errorf("a problem without node is at line %d, want the report's line 12", missing.Line)
+	}
+}
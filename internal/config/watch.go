@@ -0,0 +1,88 @@
+package config
+
+import (
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay coalesces the burst of events an editor save produces.
+const reloadDelay = 300 * time.Millisecond
+
+// WatchRepository reloads the repository at path whenever it or one of its
+// SQL files changes, and passes the validated result or the error to onLoad.
+// The directories are watched rather than the files, so editors that save
+// by renaming are followed.
+func WatchRepository(path string, onLoad func(*Repository, error)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	watched := map[string]bool{filepath.Clean(path): true}
+	// watchSQL adds the files and directories of the repository's SQL files
+	watchSQL := func(repo *Repository) {
+		if repo == nil {
+			return
+		}
+		for _, report := range repo.Reports {
+			if report.SQLFile == "" {
+				continue
+			}
+			sqlPath := report.SQLFile
+			if !filepath.IsAbs(sqlPath) {
+				sqlPath = filepath.Join(filepath.Dir(path), sqlPath)
+			}
+			sqlPath = filepath.Clean(sqlPath)
+			if !watched[sqlPath] {
+				watched[sqlPath] = true
+				if err := watcher.Add(filepath.Dir(sqlPath)); err != nil {
+					log.Printf("Cannot watch %s: %v", filepath.Dir(sqlPath), err)
+				}
+			}
+		}
+	}
+	if repo, err := LoadRepository(path); err == nil {
+		watchSQL(repo)
+	}
+
+	go func() {
+		defer watcher.Close()
+		var timer <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if watched[filepath.Clean(event.Name)] && !event.Has(fsnotify.Chmod) {
+					timer = time.After(reloadDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Repository watcher error: %v", err)
+			case <-timer:
+				timer = nil
+				repo, err := LoadRepository(path)
+				if err == nil {
+					err = repo.Validate()
+				}
+				if err != nil {
+					onLoad(nil, err)
+					continue
+				}
+				watchSQL(repo)
+				onLoad(repo, nil)
+			}
+		}
+	}()
+	return nil
+}
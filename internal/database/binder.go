@@ -0,0 +1,67 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// anyCallRe matches SQL text ending right inside an "= ANY(" call, where a
+// list value must be bound as a single array parameter.
+var anyCallRe = regexp.MustCompile(`(?i)\bANY\s*\(\s*$`)
+
+// Binder collects positional bind arguments for a query and hands out the
+// matching $n placeholders.
+type Binder struct {
+	Args []interface{}
+}
+
+// Bind appends val to the arguments and returns its placeholder.
+func (b *Binder) Bind(val interface{}) string {
+	b.Args = append(b.Args, val)
+	return fmt.Sprintf("$%d", len(b.Args))
+}
+
+// BindList binds every element of the slice val separately and returns the
+// comma separated placeholders, suitable for "IN (...)". An empty list
+// renders as NULL so that "IN (NULL)" matches nothing.
+func (b *Binder) BindList(val interface{}) string {
+	rv := reflect.ValueOf(val)
+	if rv.Len() == 0 {
+		return "NULL"
+	}
+	placeholders := make([]string, rv.Len())
+	for i := range placeholders {
+		placeholders[i] = b.Bind(rv.Index(i).Interface())
+	}
+	return strings.Join(placeholders, ", ")
+}
+
+// BindArray binds the slice val as a single Postgres array parameter.
+func (b *Binder) BindArray(val interface{}) string {
+	return b.Bind(pq.Array(val))
+}
+
+// arrayOf returns the scalar val as a one-element slice of its type.
+func arrayOf(val interface{}) interface{} {
+	if val == nil {
+		return []interface{}{nil}
+	}
+	s := reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(val)), 1, 1)
+	s.Index(0).Set(reflect.ValueOf(val))
+	return s.Interface()
+}
+
+func isList(val interface{}) bool {
+	if val == nil {
+		return false
+	}
+	if _, ok := val.([]byte); ok {
+		return false
+	}
+	kind := reflect.TypeOf(val).Kind()
+	return kind == reflect.Slice || kind == reflect.Array
+}
@@ -3,8 +3,10 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"sort"
 	"sync"
 	"time"
 
@@ -14,21 +16,52 @@ import (
 	_ "github.com/lib/pq"
 )
 
+var (
+	ErrNoSession    = errors.New("no active session")
+	ErrSessionOwner = errors.New("cursor belongs to another session")
+	// ErrPoolExhausted is returned when every cursor slot is busy
+	ErrPoolExhausted = errors.New("too many reports are open, please try again shortly")
+)
+
 type CursorState struct {
+	// Owner is the browser session that declared the cursor
+	Owner      string
 	CursorName string
 	Conn       *sql.Conn
 	Tx         *sql.Tx
-	LastUsed   time.Time
+	// LastUsed and CreatedAt are guarded by the pool's mutex
+	LastUsed  time.Time
+	CreatedAt time.Time
 	sync.Mutex
 	PageSize int
+	Totals   map[string]interface{}
+	// Offset is the 0-based row of the first row of the current page
+	Offset int
+	// RowCount is the number of rows of the query, -1 while unknown
+	RowCount int64
+	// BackendPID is the Postgres backend serving the cursor's connection
+	BackendPID int
+	// closed is set once the cursor was closed by eviction or timeout
+	closed bool
+}
+
+// declaration is a cursor being declared, whose query can be canceled.
+type declaration struct {
+	owner string
+	pid   int
 }
 
 type CursorPool struct {
-	db                 *sql.DB
-	cursors            map[string]*CursorState
+	db      *sql.DB
+	cursors map[string]*CursorState
+	// pending counts cursors being declared, which already hold a connection
+	pending int
+	// declaring maps session IDs to the declarations in progress
+	declaring          map[string]*declaration
 	mu                 sync.Mutex
 	maxConns           int
 	idleTimeout        time.Duration
+	absoluteTimeout    time.Duration
 	DefaultPageSize    int
 	AvailablePageSizes []int
 }
@@ -38,23 +71,37 @@ func NewCursorPool(connStr string, cfg config.CursorPoolConfig) (*CursorPool, er
 	if err != nil {
 		return nil, err
 	}
+	return NewCursorPoolDB(db, cfg), nil
+}
+
+// NewCursorPoolDB returns a cursor pool on an open database.
+func NewCursorPoolDB(db *sql.DB, cfg config.CursorPoolConfig) *CursorPool {
+	if cfg.MaxConnections > 0 {
+		db.SetMaxOpenConns(cfg.MaxConnections + cfg.QueryConnections)
+	}
 
 	idleTimeout, _ := time.ParseDuration(cfg.IdleTimeout)
 	if idleTimeout == 0 {
 		idleTimeout = 30 * time.Second
 	}
+	absoluteTimeout, _ := time.ParseDuration(cfg.AbsoluteTimeout)
+	if absoluteTimeout == 0 {
+		absoluteTimeout = 5 * time.Minute
+	}
 
 	pool := &CursorPool{
 		db:                 db,
 		cursors:            make(map[string]*CursorState),
+		declaring:          make(map[string]*declaration),
 		maxConns:           cfg.MaxConnections,
 		idleTimeout:        idleTimeout,
+		absoluteTimeout:    absoluteTimeout,
 		DefaultPageSize:    cfg.PageSize,
 		AvailablePageSizes: cfg.AvailablePageSizes,
 	}
 
 	go pool.cleanupRoutine()
-	return pool, nil
+	return pool
 }
 
 func (p *CursorPool) Ping(ctx context.Context) error {
@@ -65,95 +112,427 @@ func (p *CursorPool) GetDB() *sql.DB {
 	return p.db
 }
 
+// closeCursor ends the cursor's transaction and releases its connection. It
+// waits for a fetch in progress on the cursor.
+func closeCursor(state *CursorState) {
+	state.Lock()
+	defer state.Unlock()
+	if state.closed {
+		return
+	}
+	state.closed = true
+	state.Tx.Rollback()
+	state.Conn.Close()
+}
+
+// cleanupRoutine closes cursors that were idle longer than the idle timeout
+// or were opened longer ago than the absolute timeout.
 func (p *CursorPool) cleanupRoutine() {
 	ticker := time.NewTicker(10 * time.Second)
 	for range ticker.C {
+		var expired []*CursorState
 		p.mu.Lock()
 		now := time.Now()
 		for id, state := range p.cursors {
 			if now.Sub(state.LastUsed) > p.idleTimeout {
 				log.Printf("Closing idle cursor: %s", id)
-				state.Tx.Rollback()
-				state.Conn.Close()
-				delete(p.cursors, id)
+			} else if now.Sub(state.CreatedAt) > p.absoluteTimeout {
+				log.Printf("Closing cursor past absolute timeout: %s", id)
+			} else {
+				continue
 			}
+			expired = append(expired, state)
+			delete(p.cursors, id)
 		}
 		p.mu.Unlock()
+
+		for _, state := range expired {
+			closeCursor(state)
+		}
+	}
+}
+
+// reserve claims a cursor slot. When all maxConns slots are taken, the least
+// recently used cursor that isn't fetching is evicted; if every cursor is
+// busy, ErrPoolExhausted is returned. The caller must release the slot.
+func (p *CursorPool) reserve() error {
+	victim, err := p.claim()
+	if victim != nil {
+		// Closing talks to the database, so other cursors don't wait for it
+		victim.Tx.Rollback()
+		victim.Conn.Close()
+	}
+	return err
+}
+
+// claim takes a slot for reserve, returning the evicted cursor that still has
+// to be closed.
+func (p *CursorPool) claim() (*CursorState, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.maxConns <= 0 || len(p.cursors)+p.pending < p.maxConns {
+		p.pending++
+		return nil, nil
+	}
+
+	ids := make([]string, 0, len(p.cursors))
+	for id := range p.cursors {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return p.cursors[ids[i]].LastUsed.Before(p.cursors[ids[j]].LastUsed)
+	})
+	for _, id := range ids {
+		state := p.cursors[id]
+		if !state.TryLock() {
+			continue
+		}
+		log.Printf("Evicting least recently used cursor: %s", id)
+		state.closed = true
+		state.Unlock()
+		delete(p.cursors, id)
+		p.pending++
+		return state, nil
 	}
+	return nil, ErrPoolExhausted
 }
 
-func (p *CursorPool) ExecuteQuery(ctx context.Context, sessionID, query string, pageSize int, params map[string]interface{}) ([]map[string]interface{}, error) {
-	processedSQL := ProcessSQL(query, params)
+// release gives back a slot claimed by reserve.
+func (p *CursorPool) release() {
+	p.mu.Lock()
+	p.pending--
+	p.mu.Unlock()
+}
 
+// ExecuteQuery declares a scroll cursor for query and returns its first page.
+// args are bound to the $n placeholders of query. A previous cursor of the
+// same sessionID is replaced, unless it belongs to another owner.
+func (p *CursorPool) ExecuteQuery(ctx context.Context, owner, sessionID, query string, pageSize int, args []interface{}) ([]map[string]interface{}, error) {
 	p.mu.Lock()
-	state, exists := p.cursors[sessionID]
+	old, exists := p.cursors[sessionID]
 	if exists {
-		state.Tx.Rollback()
-		state.Conn.Close()
+		if old.Owner != owner {
+			p.mu.Unlock()
+			return nil, ErrSessionOwner
+		}
+		delete(p.cursors, sessionID)
+	}
+	p.mu.Unlock()
+	if exists {
+		closeCursor(old)
+	}
+
+	if err := p.reserve(); err != nil {
+		return nil, err
 	}
 
 	conn, err := p.db.Conn(ctx)
 	if err != nil {
-		p.mu.Unlock()
+		p.release()
 		return nil, err
 	}
 
-	tx, err := conn.BeginTx(ctx, nil)
-	if err != nil {
+	var pid int
+	if err := conn.QueryRowContext(ctx, "SELECT pg_backend_pid()").Scan(&pid); err != nil {
 		conn.Close()
+		p.release()
+		return nil, err
+	}
+	decl := &declaration{owner: owner, pid: pid}
+	p.mu.Lock()
+	p.declaring[sessionID] = decl
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		if p.declaring[sessionID] == decl {
+			delete(p.declaring, sessionID)
+		}
 		p.mu.Unlock()
+	}()
+
+	// The transaction outlives the request: database/sql would roll it back
+	// as soon as the request context is done
+	tx, err := conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		conn.Close()
+		p.release()
 		return nil, err
 	}
 
 	cursorName := "cur_" + uuid.New().String()[:8]
-	declareQuery := fmt.Sprintf("DECLARE %s SCROLL CURSOR FOR %s", cursorName, processedSQL)
+	declareQuery := fmt.Sprintf("DECLARE %s SCROLL CURSOR FOR %s", cursorName, query)
 
-	if _, err := tx.ExecContext(ctx, declareQuery); err != nil {
+	if _, err := tx.ExecContext(ctx, declareQuery, args...); err != nil {
 		tx.Rollback()
 		conn.Close()
-		p.mu.Unlock()
+		p.release()
 		return nil, fmt.Errorf("failed to declare cursor: %w", err)
 	}
 
-	state = &CursorState{
+	now := time.Now()
+	state := &CursorState{
+		Owner:      owner,
 		CursorName: cursorName,
 		Conn:       conn,
 		Tx:         tx,
-		LastUsed:   time.Now(),
+		LastUsed:   now,
+		CreatedAt:  now,
 		PageSize:   pageSize,
+		RowCount:   -1,
+		BackendPID: pid,
 	}
+	p.mu.Lock()
+	p.pending--
+	// A concurrent request of the same tab may have declared one meanwhile
+	replaced := p.cursors[sessionID]
 	p.cursors[sessionID] = state
 	p.mu.Unlock()
+	if replaced != nil {
+		closeCursor(replaced)
+	}
 
-	return p.FetchPage(ctx, sessionID, "NEXT")
+	return p.FetchPage(ctx, owner, sessionID, "FIRST")
 }
 
-func (p *CursorPool) FetchPage(ctx context.Context, sessionID, direction string) ([]map[string]interface{}, error) {
+// cursor returns the cursor of sessionID if owner declared it, and marks it
+// as used.
+func (p *CursorPool) cursor(owner, sessionID string) (*CursorState, error) {
 	p.mu.Lock()
+	defer p.mu.Unlock()
 	state, ok := p.cursors[sessionID]
-	p.mu.Unlock()
-
 	if !ok {
-		return nil, fmt.Errorf("no active session")
+		return nil, ErrNoSession
+	}
+	if state.Owner != owner {
+		return nil, ErrSessionOwner
 	}
+	state.LastUsed = time.Now()
+	return state, nil
+}
 
+// HasCursor reports whether owner has a live cursor named sessionID.
+func (p *CursorPool) HasCursor(owner, sessionID string) bool {
+	_, err := p.cursor(owner, sessionID)
+	return err == nil
+}
+
+// SetTotals keeps the aggregate totals of a cursor's query alongside it, so
+// paging doesn't recompute them.
+func (p *CursorPool) SetTotals(owner, sessionID string, totals map[string]interface{}) {
+	if state, err := p.cursor(owner, sessionID); err == nil {
+		state.Lock()
+		state.Totals = totals
+		state.Unlock()
+	}
+}
+
+// Totals returns the totals stored with SetTotals for a cursor.
+func (p *CursorPool) Totals(owner, sessionID string) map[string]interface{} {
+	state, err := p.cursor(owner, sessionID)
+	if err != nil {
+		return nil
+	}
 	state.Lock()
 	defer state.Unlock()
-	state.LastUsed = time.Now()
+	return state.Totals
+}
+
+// SetRowCount stores the row count of a cursor's query computed by the
+// caller, e.g. with a parallel count(*).
+func (p *CursorPool) SetRowCount(owner, sessionID string, n int64) {
+	if state, err := p.cursor(owner, sessionID); err == nil {
+		state.Lock()
+		state.RowCount = n
+		state.Unlock()
+	}
+}
+
+// CountRows counts the rows of a cursor by moving it to its end, and stores
+// the count. The next fetch repositions the cursor.
+func (p *CursorPool) CountRows(ctx context.Context, owner, sessionID string) (int64, error) {
+	state, err := p.cursor(owner, sessionID)
+	if err != nil {
+		return 0, err
+	}
+	state.Lock()
+	defer state.Unlock()
+	if state.closed {
+		return 0, ErrNoSession
+	}
+	n, err := countRows(ctx, state)
+	if err != nil {
+		p.discard(sessionID, state)
+	}
+	return n, err
+}
+
+func countRows(ctx context.Context, state *CursorState) (int64, error) {
+	if state.RowCount >= 0 {
+		return state.RowCount, nil
+	}
+	if _, err := state.Tx.ExecContext(ctx, fmt.Sprintf("MOVE ABSOLUTE 0 FROM %s", state.CursorName)); err != nil {
+		return 0, err
+	}
+	res, err := state.Tx.ExecContext(ctx, fmt.Sprintf("MOVE FORWARD ALL FROM %s", state.CursorName))
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	state.RowCount = n
+	return n, nil
+}
+
+// Position returns the offset of the first row of a cursor's current page,
+// its page size and its row count, which is -1 while unknown.
+func (p *CursorPool) Position(owner, sessionID string) (int, int, int64, error) {
+	state, err := p.cursor(owner, sessionID)
+	if err != nil {
+		return 0, 0, -1, err
+	}
+	state.Lock()
+	defer state.Unlock()
+	if state.closed {
+		return 0, 0, -1, ErrNoSession
+	}
+	return state.Offset, state.PageSize, state.RowCount, nil
+}
+
+func (p *CursorPool) FetchPage(ctx context.Context, owner, sessionID, direction string) ([]map[string]interface{}, error) {
+	state, err := p.cursor(owner, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	state.Lock()
+	defer state.Unlock()
+	if state.closed {
+		return nil, ErrNoSession
+	}
 
-	fetchSQL := ""
+	offset := state.Offset
 	switch direction {
 	case "NEXT":
-		fetchSQL = fmt.Sprintf("FETCH FORWARD %d FROM %s", state.PageSize, state.CursorName)
+		offset += state.PageSize
+		// Past the end the last page stays
+		if state.RowCount >= 0 && int64(offset) >= state.RowCount {
+			offset = state.Offset
+		}
 	case "PREV":
-		fetchSQL = fmt.Sprintf("MOVE RELATIVE -%d FROM %s; FETCH FORWARD %d FROM %s", 2*state.PageSize, state.CursorName, state.PageSize, state.CursorName)
+		offset -= state.PageSize
 	case "FIRST":
-		fetchSQL = fmt.Sprintf("MOVE ABSOLUTE 0 FROM %s; FETCH FORWARD %d FROM %s", state.CursorName, state.PageSize, state.CursorName)
+		offset = 0
 	case "LAST":
-		fetchSQL = fmt.Sprintf("MOVE LAST FROM %s; MOVE RELATIVE -%d FROM %s; FETCH FORWARD %d FROM %s", state.CursorName, state.PageSize-1, state.CursorName, state.PageSize, state.CursorName)
+		n, err := countRows(ctx, state)
+		if err != nil {
+			p.discard(sessionID, state)
+			return nil, err
+		}
+		offset = 0
+		if n > 0 {
+			offset = int((n - 1) / int64(state.PageSize) * int64(state.PageSize))
+		}
+	default:
+		return nil, fmt.Errorf("unknown direction %q", direction)
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	results, err := fetchAt(ctx, state, offset)
+	if err != nil {
+		p.discard(sessionID, state)
+	}
+	return results, err
+}
+
+// discard closes a cursor whose transaction failed, e.g. because its query
+// was canceled. The caller holds the cursor's lock.
+func (p *CursorPool) discard(sessionID string, state *CursorState) {
+	state.closed = true
+	state.Tx.Rollback()
+	state.Conn.Close()
+
+	p.mu.Lock()
+	if p.cursors[sessionID] == state {
+		delete(p.cursors, sessionID)
+	}
+	p.mu.Unlock()
+}
+
+// Cancel cancels the running query of a cursor, or of its declaration in
+// progress, with pg_cancel_backend. It reports whether a backend was signaled;
+// an idle cursor isn't.
+func (p *CursorPool) Cancel(ctx context.Context, owner, sessionID string) (bool, error) {
+	pid := 0
+	p.mu.Lock()
+	if d, ok := p.declaring[sessionID]; ok {
+		if d.owner != owner {
+			p.mu.Unlock()
+			return false, ErrSessionOwner
+		}
+		pid = d.pid
+	} else if state, ok := p.cursors[sessionID]; ok {
+		if state.Owner != owner {
+			p.mu.Unlock()
+			return false, ErrSessionOwner
+		}
+		// A fetch holds the cursor's lock while its query runs
+		if state.TryLock() {
+			state.Unlock()
+			p.mu.Unlock()
+			return false, nil
+		}
+		pid = state.BackendPID
+	}
+	p.mu.Unlock()
+	if pid == 0 {
+		return false, ErrNoSession
+	}
+
+	var signaled bool
+	err := p.db.QueryRowContext(ctx, "SELECT pg_cancel_backend($1)", pid).Scan(&signaled)
+	return signaled, err
+}
+
+// FetchAt returns the page of a cursor starting at the 0-based row offset.
+// An offset past a known end returns the last page.
+func (p *CursorPool) FetchAt(ctx context.Context, owner, sessionID string, offset int) ([]map[string]interface{}, error) {
+	state, err := p.cursor(owner, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	state.Lock()
+	defer state.Unlock()
+	if state.closed {
+		return nil, ErrNoSession
+	}
+
+	if state.RowCount >= 0 && int64(offset) >= state.RowCount {
+		offset = 0
+		if state.RowCount > 0 {
+			offset = int((state.RowCount - 1) / int64(state.PageSize) * int64(state.PageSize))
+		}
+	}
+	if offset < 0 {
+		offset = 0
 	}
+	results, err := fetchAt(ctx, state, offset)
+	if err != nil {
+		p.discard(sessionID, state)
+	}
+	return results, err
+}
 
-	rows, err := state.Tx.QueryContext(ctx, fetchSQL)
+// fetchAt returns the page of the cursor starting at the 0-based row offset.
+func fetchAt(ctx context.Context, state *CursorState, offset int) ([]map[string]interface{}, error) {
+	// MOVE ABSOLUTE n leaves the cursor on row n, so the fetch starts at n+1
+	if _, err := state.Tx.ExecContext(ctx, fmt.Sprintf("MOVE ABSOLUTE %d FROM %s", offset, state.CursorName)); err != nil {
+		return nil, err
+	}
+	rows, err := state.Tx.QueryContext(ctx, fmt.Sprintf("FETCH FORWARD %d FROM %s", state.PageSize, state.CursorName))
 	if err != nil {
 		return nil, err
 	}
@@ -180,5 +559,14 @@ func (p *CursorPool) FetchPage(ctx context.Context, sessionID, direction string)
 		}
 		results = append(results, row)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	state.Offset = offset
+	// A short page reveals the row count for free
+	if state.RowCount < 0 && len(results) < state.PageSize {
+		state.RowCount = int64(offset + len(results))
+	}
 	return results, nil
 }
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"GoBI/internal/config"
+	"GoBI/internal/dbtest"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPool returns a pool of maxConns cursors on a fake database whose
+// cursors hold the rows 1 to 25.
+func testPool(t *testing.T, maxConns int) (*CursorPool, *dbtest.DB) {
+	db := dbtest.Open(func(query string, args []driver.Value) dbtest.Result {
+		switch {
+		case strings.HasPrefix(query, "SELECT pg_backend_pid()"):
+			return dbtest.Result{Columns: []string{"pid"}, Rows: [][]driver.Value{{int64(42)}}}
+		case strings.HasPrefix(query, "SELECT pg_cancel_backend"):
+			return dbtest.Result{Columns: []string{"signaled"}, Rows: [][]driver.Value{{true}}}
+		case strings.HasPrefix(query, "FETCH"):
+			res := dbtest.Result{Columns: []string{"n"}}
+			for n := int64(1); n <= 10; n++ {
+				res.Rows = append(res.Rows, []driver.Value{n})
+			}
+			return res
+		}
+		return dbtest.Result{}
+	})
+	return NewCursorPoolDB(db.DB, config.CursorPoolConfig{MaxConnections: maxConns, QueryConnections: 1}), db
+}
+
+func open(t *testing.T, p *CursorPool, sessionID string) {
+	t.Helper()
+	if _, err := p.ExecuteQuery(context.Background(), "owner", sessionID, "SELECT 1", 10, nil); err != nil {
+		t.Fatalf("%s: %v", sessionID, err)
+	}
+}
+
+func TestReserveEvictsLeastRecentlyUsed(t *testing.T) {
+	p, db := testPool(t, 2)
+	open(t, p, "a")
+	open(t, p, "b")
+	time.Sleep(time.Millisecond)
+	p.HasCursor("owner", "a")
+
+	// Other cursors stay usable while the evicted one is closed
+	db.Hook("ROLLBACK", func() {
+		done := make(chan bool)
+		go func() { done <- p.HasCursor("owner", "a") }()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("the pool is locked while the evicted cursor is closed")
+		}
+	})
+	open(t, p, "c")
+
+	for id, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if p.HasCursor("owner", id) != want {
+			t.Errorf("cursor %s open = %v, want %v", id, !want, want)
+		}
+	}
+	if db.Count("ROLLBACK") != 1 {
+		t.Errorf("%d rollbacks, want 1", db.Count("ROLLBACK"))
+	}
+	if _, err := p.FetchPage(context.Background(), "owner", "b", "NEXT"); !errors.Is(err, ErrNoSession) {
+		t.Errorf("fetch of the evicted cursor: %v", err)
+	}
+}
+
+func TestReserveSkipsBusyCursors(t *testing.T) {
+	p, _ := testPool(t, 1)
+	open(t, p, "a")
+
+	// A fetch in progress holds the cursor's lock
+	state := p.cursors["a"]
+	state.Lock()
+	_, err := p.ExecuteQuery(context.Background(), "owner", "b", "SELECT 1", 10, nil)
+	state.Unlock()
+	if !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("err = %v, want ErrPoolExhausted", err)
+	}
+	if !p.HasCursor("owner", "a") {
+		t.Error("the busy cursor was evicted")
+	}
+}
+
+func TestFetchAtOwner(t *testing.T) {
+	p, db := testPool(t, 2)
+	open(t, p, "a")
+	if _, err := p.FetchAt(context.Background(), "other", "a", 0); !errors.Is(err, ErrSessionOwner) {
+		t.Errorf("fetch by another owner: %v", err)
+	}
+	if _, err := p.FetchAt(context.Background(), "owner", "a", 20); err != nil {
+		t.Fatal(err)
+	}
+	if db.Count("MOVE ABSOLUTE 20 ") != 1 {
+		t.Errorf("statements %v", db.Statements())
+	}
+	if offset, pageSize, _, err := p.Position("owner", "a"); offset != 20 || pageSize != 10 || err != nil {
+		t.Errorf("position %d, %d, %v", offset, pageSize, err)
+	}
+}
+
+func TestCancelOnlyRunningQueries(t *testing.T) {
+	p, db := testPool(t, 2)
+	open(t, p, "a")
+
+	if signaled, err := p.Cancel(context.Background(), "owner", "a"); signaled || err != nil {
+		t.Errorf("cancel of an idle cursor: %v, %v", signaled, err)
+	}
+	if db.Count("SELECT pg_cancel_backend") != 0 {
+		t.Error("an idle cursor's backend was signaled")
+	}
+
+	state := p.cursors["a"]
+	state.Lock()
+	_, err := p.Cancel(context.Background(), "owner", "a")
+	state.Unlock()
+	if err != nil || db.Count("SELECT pg_cancel_backend") != 1 {
+		t.Errorf("cancel of a fetching cursor: %v, statements %v", err, db.Statements())
+	}
+	if _, err := p.Cancel(context.Background(), "other", "a"); !errors.Is(err, ErrSessionOwner) {
+		t.Errorf("cancel by another owner: %v", err)
+	}
+}
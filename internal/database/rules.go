@@ -29,7 +29,41 @@ func GetDefaultRules() []Rule {
 	return rules
 }
 
+// ProcessSQL renders sqlText, splicing input values into the SQL as text.
 func ProcessSQL(sqlText string, inputMap map[string]interface{}) string {
+	return processSQL(sqlText, inputMap, func(key string, val interface{}, _ string) string {
+		return fmt.Sprintf("%v", val)
+	})
+}
+
+// ProcessSQLArgs renders sqlText with every replaced value turned into a
+// positional $n placeholder and returns the matching bind arguments.
+func ProcessSQLArgs(sqlText string, inputMap map[string]interface{}) (string, []interface{}) {
+	var b Binder
+	return ProcessSQLBind(sqlText, inputMap, &b), b.Args
+}
+
+// ProcessSQLBind is ProcessSQLArgs numbering placeholders after the arguments
+// already collected in b, so the result can be embedded in a larger query.
+// Every use of a value gets its own placeholder, as Postgres deduces one type
+// per placeholder.
+func ProcessSQLBind(sqlText string, inputMap map[string]interface{}, b *Binder) string {
+	return processSQL(sqlText, inputMap, func(key string, val interface{}, before string) string {
+		// ANY takes an array, even of a single value
+		if anyCallRe.MatchString(before) {
+			if !isList(val) {
+				val = arrayOf(val)
+			}
+			return b.BindArray(val)
+		}
+		if isList(val) {
+			return b.BindList(val)
+		}
+		return b.Bind(val)
+	})
+}
+
+func processSQL(sqlText string, inputMap map[string]interface{}, replace func(key string, val interface{}, before string) string) string {
 	rules := GetDefaultRules()
 	var result strings.Builder
 	scanner := bufio.NewScanner(strings.NewReader(sqlText))
@@ -86,14 +120,8 @@ func ProcessSQL(sqlText string, inputMap map[string]interface{}) string {
 					}
 				}
 			}
-			if rule.Action == "replace" {
-				line = rule.Re.ReplaceAllStringFunc(line, func(match string) string {
-					key := match[1:]
-					if val, ok := inputMap[key]; ok {
-						return fmt.Sprintf("%v", val)
-					}
-					return match
-				})
+			if rule.Action == "replace" && !lineDeleted {
+				line = replaceTokens(rule.Re, line, result.String(), inputMap, replace)
 			}
 		}
 
@@ -103,3 +131,22 @@ func ProcessSQL(sqlText string, inputMap map[string]interface{}) string {
 	}
 	return result.String()
 }
+
+// replaceTokens replaces the tokens of line; before, the text preceding a
+// token, starts with the already rendered lines.
+func replaceTokens(re *regexp.Regexp, line, rendered string, inputMap map[string]interface{}, replace func(key string, val interface{}, before string) string) string {
+	var out strings.Builder
+	last := 0
+	for _, loc := range re.FindAllStringSubmatchIndex(line, -1) {
+		key := line[loc[2]:loc[3]]
+		val, ok := inputMap[key]
+		if !ok {
+			continue
+		}
+		out.WriteString(line[last:loc[0]])
+		out.WriteString(replace(key, val, rendered+out.String()))
+		last = loc[1]
+	}
+	out.WriteString(line[last:])
+	return out.String()
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestProcessSQLBindAny(t *testing.T) {
+	tests := []struct {
+		name string
+		val  interface{}
+		want interface{}
+	}{
+		{"list", []string{"a", "b"}, pq.Array([]string{"a", "b"})},
+		{"scalar string", "a", pq.Array([]string{"a"})},
+		{"scalar int", int64(7), pq.Array([]int64{7})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b Binder
+			got := ProcessSQLBind("SELECT 1 WHERE x = ANY(:v)", map[string]interface{}{"v": tt.val}, &b)
+			if strings.TrimSpace(got) != "SELECT 1 WHERE x = ANY($1)" {
+				t.Errorf("sql = %q", got)
+			}
+			if len(b.Args) != 1 || !reflect.DeepEqual(b.Args[0], tt.want) {
+				t.Errorf("args = %#v, want %#v", b.Args, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessSQLBind(t *testing.T) {
+	tests := []struct {
+		name  string
+		sql   string
+		input map[string]interface{}
+		want  string
+		args  []interface{}
+	}{
+		{
+			name:  "repeated scalar gets a placeholder per use",
+			sql:   "SELECT 1 WHERE a = :d::date OR b = :d || 'x' AND c = :y",
+			input: map[string]interface{}{"d": "2024-01-01", "y": "z"},
+			want:  "SELECT 1 WHERE a = $1::date OR b = $2 || 'x' AND c = $3",
+			args:  []interface{}{"2024-01-01", "2024-01-01", "z"},
+		},
+		{
+			name:  "ANY call across lines",
+			sql:   "SELECT 1 WHERE a = ANY(\n  :ids)",
+			input: map[string]interface{}{"ids": "p"},
+			want:  "SELECT 1 WHERE a = ANY(\n  $1)",
+			args:  []interface{}{pq.Array([]string{"p"})},
+		},
+		{
+			name:  "IN list",
+			sql:   "SELECT 1 WHERE a IN (:ids)",
+			input: map[string]interface{}{"ids": []string{"p", "q", "r"}},
+			want:  "SELECT 1 WHERE a IN ($1, $2, $3)",
+			args:  []interface{}{"p", "q", "r"},
+		},
+		{
+			name:  "empty IN list",
+			sql:   "SELECT 1 WHERE a IN (:ids)",
+			input: map[string]interface{}{"ids": []string{}},
+			want:  "SELECT 1 WHERE a IN (NULL)",
+		},
+		{
+			name:  "empty ANY list",
+			sql:   "SELECT 1 WHERE a = any ( :ids)",
+			input: map[string]interface{}{"ids": []string{}},
+			want:  "SELECT 1 WHERE a = any ( $1)",
+			args:  []interface{}{pq.Array([]string{})},
+		},
+		{
+			name:  "lists and scalars in order",
+			sql:   "SELECT 1 WHERE a IN (:ids) AND b = ANY(:ids) AND c = :x",
+			input: map[string]interface{}{"ids": []int64{4, 5}, "x": "v"},
+			want:  "SELECT 1 WHERE a IN ($1, $2) AND b = ANY($3) AND c = $4",
+			args:  []interface{}{int64(4), int64(5), pq.Array([]int64{4, 5}), "v"},
+		},
+		{
+			name:  "missing value drops its line",
+			sql:   "SELECT 1\nWHERE true\n  AND a = :x -- #x",
+			input: map[string]interface{}{},
+			want:  "SELECT 1\nWHERE true",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args := ProcessSQLArgs(tt.sql, tt.input)
+			if strings.TrimSpace(got) != tt.want {
+				t.Errorf("sql = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %#v, want %#v", args, tt.args)
+			}
+		})
+	}
+}
+
+// ProcessSQLBind numbers placeholders after the arguments already bound.
+func TestProcessSQLBindContinues(t *testing.T) {
+	b := Binder{Args: []interface{}{"earlier"}}
+	got := ProcessSQLBind("a = :x AND b IN (:ids)", map[string]interface{}{"x": 1, "ids": []string{"p", "q"}}, &b)
+	if strings.TrimSpace(got) != "a = $2 AND b IN ($3, $4)" {
+		t.Errorf("sql = %q", got)
+	}
+	if len(b.Args) != 4 {
+		t.Errorf("args = %v", b.Args)
+	}
+}
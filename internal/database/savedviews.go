@@ -0,0 +1,159 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrViewNotFound is returned for a saved view that doesn't exist or that
+// the user may not see.
+var ErrViewNotFound = errors.New("saved view not found")
+
+// savedViewsDDL creates the GoBI metadata table of saved views in the
+// connection's schema.
+const savedViewsDDL = `CREATE TABLE IF NOT EXISTS gobi_saved_view (
+	id         bigserial PRIMARY KEY,
+	report_id  text NOT NULL,
+	owner      text NOT NULL,
+	name       text NOT NULL,
+	filters    jsonb NOT NULL DEFAULT '{}',
+	sort       jsonb NOT NULL DEFAULT '[]',
+	columns    jsonb NOT NULL DEFAULT '[]',
+	hidden     jsonb NOT NULL DEFAULT '[]',
+	page_size  integer NOT NULL DEFAULT 0,
+	shared     boolean NOT NULL DEFAULT false,
+	updated_at timestamptz NOT NULL DEFAULT now(),
+	UNIQUE (report_id, owner, name)
+)`
+
+// SavedView is a named set of report settings: the query parameters and
+// filters, the sort, the column order and visibility and the page size.
+type SavedView struct {
+	ID       int64  `json:"id"`
+	ReportID string `json:"report_id"`
+	Owner    string `json:"-"`
+	Name     string `json:"name"`
+	// Filters are the report parameters, filters and grouping as request
+	// parameters
+	Filters map[string][]string `json:"filters"`
+	Sort    []string            `json:"sort"`
+	// Columns is the column order, Hidden the columns switched off
+	Columns  []string `json:"columns"`
+	Hidden   []string `json:"hidden"`
+	PageSize int      `json:"page_size"`
+	// Shared views can be loaded by every user
+	Shared    bool      `json:"shared"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+const savedViewColumns = "id, report_id, owner, name, filters, sort, columns, hidden, page_size, shared, updated_at"
+
+// CreateSavedViews creates the saved view table if it is missing.
+func CreateSavedViews(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, savedViewsDDL)
+	return err
+}
+
+// SaveView stores v, replacing the owner's view of the same report and
+// name, and sets its ID and update time.
+func SaveView(ctx context.Context, db *sql.DB, v *SavedView) error {
+	var args []interface{}
+	for _, field := range []interface{}{v.Filters, v.Sort, v.Columns, v.Hidden} {
+		data, err := json.Marshal(field)
+		if err != nil {
+			return err
+		}
+		args = append(args, string(data))
+	}
+	return db.QueryRowContext(ctx, `
+		INSERT INTO gobi_saved_view (report_id, owner, name, filters, sort, columns, hidden, page_size, shared)
+		VALUES ($1, $2, $3, $4::jsonb, $5::jsonb, $6::jsonb, $7::jsonb, $8, $9)
+		ON CONFLICT (report_id, owner, name) DO UPDATE SET
+			filters = EXCLUDED.filters, sort = EXCLUDED.sort, columns = EXCLUDED.columns,
+			hidden = EXCLUDED.hidden, page_size = EXCLUDED.page_size, shared = EXCLUDED.shared,
+			updated_at = now()
+		RETURNING id, updated_at`,
+		v.ReportID, v.Owner, v.Name, args[0], args[1], args[2], args[3], v.PageSize, v.Shared,
+	).Scan(&v.ID, &v.UpdatedAt)
+}
+
+// SavedViews returns the views of a report that user can load: their own
+// and the shared ones, by name.
+func SavedViews(ctx context.Context, db *sql.DB, reportID, user string) ([]SavedView, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+savedViewColumns+` FROM gobi_saved_view
+		WHERE report_id = $1 AND (owner = $2 OR shared)
+		ORDER BY name, owner`, reportID, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var views []SavedView
+	for rows.Next() {
+		v, err := scanView(rows)
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, *v)
+	}
+	return views, rows.Err()
+}
+
+// LoadView returns the saved view id if user owns it or it is shared.
+func LoadView(ctx context.Context, db *sql.DB, id int64, user string) (*SavedView, error) {
+	v, err := scanView(db.QueryRowContext(ctx, `
+		SELECT `+savedViewColumns+` FROM gobi_saved_view
+		WHERE id = $1 AND (owner = $2 OR shared)`, id, user))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrViewNotFound
+	}
+	return v, err
+}
+
+// ShareView shares or unshares the owner's saved view id.
+func ShareView(ctx context.Context, db *sql.DB, id int64, owner string, shared bool) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE gobi_saved_view SET shared = $3, updated_at = now()
+		WHERE id = $1 AND owner = $2`, id, owner, shared)
+	return affectedView(res, err)
+}
+
+// DeleteView deletes the owner's saved view id.
+func DeleteView(ctx context.Context, db *sql.DB, id int64, owner string) error {
+	res, err := db.ExecContext(ctx, `DELETE FROM gobi_saved_view WHERE id = $1 AND owner = $2`, id, owner)
+	return affectedView(res, err)
+}
+
+func affectedView(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrViewNotFound
+	}
+	return nil
+}
+
+func scanView(row interface{ Scan(...interface{}) error }) (*SavedView, error) {
+	var v SavedView
+	var filters, sort, columns, hidden []byte
+	err := row.Scan(&v.ID, &v.ReportID, &v.Owner, &v.Name, &filters, &sort, &columns, &hidden, &v.PageSize, &v.Shared, &v.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range []struct {
+		data []byte
+		dst  interface{}
+	}{{filters, &v.Filters}, {sort, &v.Sort}, {columns, &v.Columns}, {hidden, &v.Hidden}} {
+		if err := json.Unmarshal(f.data, f.dst); err != nil {
+			return nil, err
+		}
+	}
+	return &v, nil
+}
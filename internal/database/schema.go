@@ -0,0 +1,157 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+// ColumnInfo is a column of a table or query result.
+type ColumnInfo struct {
+	Name     string
+	DataType string
+	Comment  string
+}
+
+// TableInfo describes a table or view for introspection.
+type TableInfo struct {
+	Name    string
+	Comment string
+	Columns []ColumnInfo
+	// ForeignKeys holds the table's single-column foreign keys
+	ForeignKeys []ForeignKey
+}
+
+// ForeignKey links Column to RefColumn of RefSchema.RefTable.
+type ForeignKey struct {
+	Column    string
+	RefSchema string
+	RefTable  string
+	RefColumn string
+}
+
+// RefName returns the schema-qualified name of the referenced table.
+func (fk ForeignKey) RefName() string {
+	return fk.RefSchema + "." + fk.RefTable
+}
+
+// TableColumns lists the columns of schema.table in order. A missing table
+// has no columns.
+func TableColumns(ctx context.Context, db *sql.DB, schema, table string) ([]ColumnInfo, error) {
+	rows, err := db.QueryContext(ctx, `SELECT column_name, data_type
+FROM information_schema.columns
+WHERE table_schema = $1 AND table_name = $2
+ORDER BY ordinal_position`, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cols []ColumnInfo
+	for rows.Next() {
+		var col ColumnInfo
+		if err := rows.Scan(&col.Name, &col.DataType); err != nil {
+			return nil, err
+		}
+		cols = append(cols, col)
+	}
+	return cols, rows.Err()
+}
+
+// QueryColumns returns the result columns of query without fetching any row.
+func QueryColumns(ctx context.Context, db *sql.DB, query string, args []interface{}) ([]ColumnInfo, error) {
+	rows, err := db.QueryContext(ctx, "SELECT * FROM ("+query+") AS q LIMIT 0", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	cols := make([]ColumnInfo, len(types))
+	for i, t := range types {
+		cols[i] = ColumnInfo{Name: t.Name(), DataType: t.DatabaseTypeName()}
+	}
+	return cols, nil
+}
+
+// DescribeTables returns the tables and views of schema whose name matches
+// the LIKE pattern, with their columns, comments and foreign keys.
+func DescribeTables(ctx context.Context, db *sql.DB, schema, pattern string) ([]TableInfo, error) {
+	rows, err := db.QueryContext(ctx, `SELECT c.relname, coalesce(obj_description(c.oid, 'pg_class'), '')
+FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = $1 AND c.relname LIKE $2 AND c.relkind IN ('r', 'v', 'm', 'p')
+ORDER BY c.relname`, schema, pattern)
+	if err != nil {
+		return nil, err
+	}
+	var tables []TableInfo
+	index := make(map[string]int)
+	for rows.Next() {
+		var t TableInfo
+		if err := rows.Scan(&t.Name, &t.Comment); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		index[t.Name] = len(tables)
+		tables = append(tables, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.QueryContext(ctx, `SELECT c.relname, a.attname, format_type(a.atttypid, a.atttypmod),
+	coalesce(col_description(c.oid, a.attnum), '')
+FROM pg_attribute a
+JOIN pg_class c ON c.oid = a.attrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = $1 AND c.relname LIKE $2 AND a.attnum > 0 AND NOT a.attisdropped
+ORDER BY c.relname, a.attnum`, schema, pattern)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var table string
+		var col ColumnInfo
+		if err := rows.Scan(&table, &col.Name, &col.DataType, &col.Comment); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if i, ok := index[table]; ok {
+			tables[i].Columns = append(tables[i].Columns, col)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.QueryContext(ctx, `SELECT c.relname, a.attname, rn.nspname, rc.relname, ra.attname
+FROM pg_constraint con
+JOIN pg_class c ON c.oid = con.conrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+JOIN pg_class rc ON rc.oid = con.confrelid
+JOIN pg_namespace rn ON rn.oid = rc.relnamespace
+JOIN pg_attribute a ON a.attrelid = con.conrelid AND a.attnum = con.conkey[1]
+JOIN pg_attribute ra ON ra.attrelid = con.confrelid AND ra.attnum = con.confkey[1]
+WHERE con.contype = 'f' AND n.nspname = $1 AND c.relname LIKE $2 AND cardinality(con.conkey) = 1
+ORDER BY c.relname, con.conname`, schema, pattern)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table string
+		var fk ForeignKey
+		if err := rows.Scan(&table, &fk.Column, &fk.RefSchema, &fk.RefTable, &fk.RefColumn); err != nil {
+			return nil, err
+		}
+		if i, ok := index[table]; ok {
+			tables[i].ForeignKeys = append(tables[i].ForeignKeys, fk)
+		}
+	}
+	return tables, rows.Err()
+}
@@ -0,0 +1,200 @@
+// Package dbtest is a scripted database/sql driver for tests of code that
+// needs a *sql.DB but no real database.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Result is the answer to a statement: the columns and rows of a query, or
+// an error.
+type Result struct {
+	Columns []string
+	Rows    [][]driver.Value
+	Err     error
+}
+
+// Handler answers the statements run on a DB; args are the bound values.
+type Handler func(query string, args []driver.Value) Result
+
+// DB is a fake database answering statements with its handler.
+type DB struct {
+	*sql.DB
+	handler Handler
+
+	mu    sync.Mutex
+	log   []string
+	hooks map[string]func()
+}
+
+var (
+	drv   = &fakeDriver{dbs: make(map[string]*DB)}
+	count atomic.Int64
+)
+
+func init() {
+	sql.Register("dbtest", drv)
+}
+
+// Open returns a fake database answering with handler, or with empty
+// results when handler is nil.
+func Open(handler Handler) *DB {
+	if handler == nil {
+		handler = func(string, []driver.Value) Result { return Result{} }
+	}
+	name := fmt.Sprintf("db%d", count.Add(1))
+	db := &DB{handler: handler, hooks: make(map[string]func())}
+	drv.mu.Lock()
+	drv.dbs[name] = db
+	drv.mu.Unlock()
+	db.DB, _ = sql.Open("dbtest", name)
+	return db
+}
+
+// Statements returns the statements run so far, with BEGIN, COMMIT,
+// ROLLBACK and CLOSE for transactions and connections.
+func (db *DB) Statements() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]string{}, db.log...)
+}
+
+// Count returns how many statements so far start with prefix.
+func (db *DB) Count(prefix string) int {
+	n := 0
+	for _, s := range db.Statements() {
+		if strings.HasPrefix(s, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+// Hook runs f whenever the event, e.g. ROLLBACK, happens, before it
+// completes.
+func (db *DB) Hook(event string, f func()) {
+	db.mu.Lock()
+	db.hooks[event] = f
+	db.mu.Unlock()
+}
+
+func (db *DB) record(stmt string) {
+	db.mu.Lock()
+	db.log = append(db.log, stmt)
+	hook := db.hooks[stmt]
+	db.mu.Unlock()
+	if hook != nil {
+		hook()
+	}
+}
+
+type fakeDriver struct {
+	mu  sync.Mutex
+	dbs map[string]*DB
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	d.mu.Lock()
+	db, ok := d.dbs[name]
+	d.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("dbtest: unknown database %q", name)
+	}
+	return &conn{db: db}, nil
+}
+
+type conn struct {
+	db *DB
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("dbtest: prepared statements are not supported")
+}
+
+func (c *conn) Close() error {
+	c.db.record("CLOSE")
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	c.db.record("BEGIN")
+	return tx{c.db}, nil
+}
+
+func (c *conn) BeginTx(ctx context.Context, _ driver.TxOptions) (driver.Tx, error) {
+	return c.Begin()
+}
+
+func (c *conn) run(query string, named []driver.NamedValue) Result {
+	c.db.record(query)
+	args := make([]driver.Value, len(named))
+	for i, a := range named {
+		args[i] = a.Value
+	}
+	return c.db.handler(query, args)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res := c.run(query, args)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	return &rows{cols: res.Columns, rows: res.Rows}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res := c.run(query, args)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	return driver.RowsAffected(len(res.Rows)), nil
+}
+
+// CheckNamedValue accepts every argument as is, like pq.Array values.
+func (c *conn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+type tx struct {
+	db *DB
+}
+
+func (t tx) Commit() error {
+	t.db.record("COMMIT")
+	return nil
+}
+
+func (t tx) Rollback() error {
+	t.db.record("ROLLBACK")
+	return nil
+}
+
+type rows struct {
+	cols []string
+	rows [][]driver.Value
+	next int
+}
+
+func (r *rows) Columns() []string {
+	return r.cols
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
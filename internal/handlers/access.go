@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"GoBI/internal/auth"
+	"GoBI/internal/config"
+	"GoBI/internal/database"
+	"context"
+	"strings"
+)
+
+// canView reports whether the user of ctx may open report. Without
+// authentication every report is open.
+func canView(ctx context.Context, report *config.Report) bool {
+	u := auth.UserFrom(ctx)
+	return u == nil || len(report.Roles) == 0 || u.HasRole(report.Roles...)
+}
+
+// visibleReports returns the reports of the list the user of ctx may open.
+func visibleReports(ctx context.Context, reports []*config.Report) []*config.Report {
+	var visible []*config.Report
+	for _, report := range reports {
+		if canView(ctx, report) {
+			visible = append(visible, report)
+		}
+	}
+	return visible
+}
+
+// withUser adds the signed-in user of ctx to the ProcessSQL input of a
+// request. Without authentication the input has no user and row filters
+// don't apply.
+func withUser(ctx context.Context, input map[string]interface{}) map[string]interface{} {
+	delete(input, config.CurrentUserParam)
+	delete(input, config.CurrentRolesParam)
+	if u := auth.UserFrom(ctx); u != nil {
+		input[config.CurrentUserParam] = u.Name
+		input[config.CurrentRolesParam] = append([]string{}, u.Roles...)
+	}
+	return input
+}
+
+// rowFilter returns the condition of the report's row filters that apply to
+// the user of the input, with their values bound through b. It is empty when
+// the user's rows aren't restricted.
+func rowFilter(report *config.Report, input map[string]interface{}, b *database.Binder) string {
+	name, ok := input[config.CurrentUserParam]
+	if !ok || len(report.RowFilters) == 0 {
+		return ""
+	}
+	roles, _ := input[config.CurrentRolesParam].([]string)
+	u := &auth.User{Name: name.(string), Roles: roles}
+	userInput := map[string]interface{}{
+		config.CurrentUserParam:  name,
+		config.CurrentRolesParam: input[config.CurrentRolesParam],
+	}
+
+	var filters []string
+	for _, f := range report.RowFilters {
+		if len(f.Roles) > 0 && !u.HasRole(f.Roles...) {
+			continue
+		}
+		if strings.TrimSpace(f.Filter) == "" {
+			return ""
+		}
+		filters = append(filters, f.Filter)
+	}
+	if len(filters) == 0 {
+		return "false"
+	}
+	conds := make([]string, len(filters))
+	for i, f := range filters {
+		conds[i] = "(" + strings.TrimSpace(database.ProcessSQLBind(f, userInput, b)) + ")"
+	}
+	return strings.Join(conds, " OR ")
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"GoBI/internal/auth"
+	"GoBI/internal/config"
+	"GoBI/internal/database"
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func userCtx(name string, roles ...string) context.Context {
+	return auth.WithUser(context.Background(), &auth.User{Name: name, Roles: roles})
+}
+
+func TestCanView(t *testing.T) {
+	open := &config.Report{ID: "open"}
+	restricted := &config.Report{ID: "restricted", Roles: []string{"admin", "felelos"}}
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		report *config.Report
+		want   bool
+	}{
+		{"no authentication", context.Background(), restricted, true},
+		{"report without roles", userCtx("u1"), open, true},
+		{"one of the roles", userCtx("u1", "felelos"), restricted, true},
+		{"other role", userCtx("u1", "vezeto"), restricted, false},
+		{"no role", userCtx("u1"), restricted, false},
+	}
+	for _, tt := range tests {
+		if got := canView(tt.ctx, tt.report); got != tt.want {
+			t.Errorf("%s: canView = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRowFilter(t *testing.T) {
+	report := &config.Report{ID: "r", RowFilters: []config.RowFilter{
+		{Roles: []string{"admin"}},
+		{Roles: []string{"felelos"}, Filter: "felelos = :current_user"},
+		{Roles: []string{"vezeto"}, Filter: "osztaly = ANY(:current_roles)"},
+	}}
+	tests := []struct {
+		name  string
+		input map[string]interface{}
+		want  string
+		args  []interface{}
+	}{
+		{"no authentication", map[string]interface{}{}, "", nil},
+		{"unrestricted role", withUser(userCtx("u1", "admin", "felelos"), map[string]interface{}{}), "", nil},
+		{"own rows", withUser(userCtx("u1", "felelos"), map[string]interface{}{}), "(felelos = $1)", []interface{}{"u1"}},
+		{"any filter", withUser(userCtx("u1", "felelos", "vezeto"), map[string]interface{}{}),
+			"(felelos = $1) OR (osztaly = ANY($2))", []interface{}{"u1", pq.Array([]string{"felelos", "vezeto"})}},
+		{"no filter", withUser(userCtx("u1", "olvaso"), map[string]interface{}{}), "false", nil},
+		{"forged user", withUser(context.Background(), map[string]interface{}{config.CurrentUserParam: "admin"}), "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b database.Binder
+			if got := rowFilter(report, tt.input, &b); got != tt.want {
+				t.Errorf("rowFilter = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(b.Args, tt.args) {
+				t.Errorf("args %#v, want %#v", b.Args, tt.args)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"GoBI/internal/config"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// maxAPILimit caps the rows returned by one data request.
+const maxAPILimit = 1000
+
+type apiColumn struct {
+	Name          string `json:"name"`
+	Label         string `json:"label"`
+	Type          string `json:"type"`
+	Filterable    bool   `json:"filterable"`
+	Sortable      bool   `json:"sortable"`
+	AggregateFunc string `json:"aggregate_func,omitempty"`
+	Hidden        bool   `json:"hidden"`
+}
+
+type apiParameter struct {
+	Name     string          `json:"name"`
+	Label    string          `json:"label"`
+	Type     string          `json:"type"`
+	Required bool            `json:"required"`
+	Default  string          `json:"default,omitempty"`
+	Options  []config.Option `json:"options,omitempty"`
+}
+
+type apiReport struct {
+	ID            string              `json:"id"`
+	Title         string              `json:"title"`
+	Description   string              `json:"description"`
+	ViewType      string              `json:"view_type"`
+	Schema        string              `json:"schema,omitempty"`
+	TableName     string              `json:"table_name,omitempty"`
+	SQLFile       string              `json:"sql_file,omitempty"`
+	ParentReport  string              `json:"parent_report,omitempty"`
+	ParentColumn  string              `json:"parent_column,omitempty"`
+	ParentColumns []config.ColumnLink `json:"parent_columns,omitempty"`
+	Columns       []apiColumn         `json:"columns,omitempty"`
+	Parameters    []apiParameter      `json:"parameters,omitempty"`
+	Pivot         *config.Pivot       `json:"pivot,omitempty"`
+	Roles         []string            `json:"roles,omitempty"`
+}
+
+type apiData struct {
+	Report  string                   `json:"report"`
+	Columns []apiColumn              `json:"columns"`
+	Rows    []map[string]interface{} `json:"rows"`
+	Totals  map[string]interface{}   `json:"totals,omitempty"`
+	Limit   int                      `json:"limit"`
+	Offset  int                      `json:"offset"`
+	HasMore bool                     `json:"has_more"`
+}
+
+func toAPIColumns(cols []config.Column) []apiColumn {
+	out := make([]apiColumn, len(cols))
+	for i, col := range cols {
+		out[i] = apiColumn{
+			Name:          col.Name,
+			Label:         col.Label,
+			Type:          col.Type,
+			Filterable:    col.Filterable,
+			Sortable:      col.Sortable,
+			AggregateFunc: col.AggregateFunc,
+			Hidden:        col.Hidden,
+		}
+	}
+	return out
+}
+
+func toAPIReport(report *config.Report, withDetails bool) apiReport {
+	out := apiReport{
+		ID:            report.ID,
+		Title:         report.Title,
+		Description:   report.Description,
+		ViewType:      report.ViewType,
+		Schema:        report.Schema,
+		TableName:     report.TableName,
+		SQLFile:       report.SQLFile,
+		ParentReport:  report.ParentReport,
+		ParentColumn:  report.ParentColumn,
+		ParentColumns: report.Links(),
+		Roles:         report.Roles,
+	}
+	if withDetails {
+		out.Columns = toAPIColumns(report.Columns)
+		out.Pivot = report.Pivot
+		for _, p := range report.Parameters {
+			out.Parameters = append(out.Parameters, apiParameter{
+				Name:     p.Name,
+				Label:    p.Label,
+				Type:     p.Type,
+				Required: p.Required,
+				Default:  p.Default,
+				Options:  p.Options,
+			})
+		}
+	}
+	return out
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// APIReportsHandler lists the repository's reports.
+func APIReportsHandler(w http.ResponseWriter, r *http.Request) {
+	rp := repository()
+	reports := make([]apiReport, 0, len(rp.Reports))
+	for i := range rp.Reports {
+		if canView(r.Context(), &rp.Reports[i]) {
+			reports = append(reports, toAPIReport(&rp.Reports[i], false))
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"repository": rp.Meta,
+		"reports":    reports,
+	})
+}
+
+// APIReportHandler returns the metadata of one report.
+func APIReportHandler(w http.ResponseWriter, r *http.Request) {
+	report := findReport(r.PathValue("id"))
+	if report == nil {
+		writeJSONError(w, http.StatusNotFound, "Report not found")
+		return
+	}
+	if !canView(r.Context(), report) {
+		writeJSONError(w, http.StatusForbidden, "Access to this report is denied")
+		return
+	}
+	writeJSON(w, http.StatusOK, toAPIReport(report, true))
+}
+
+// APIReportDataHandler returns a page of a report's result. It accepts the
+// same parameter, filter, sort and group parameters as the report page, and
+// pages with limit/offset.
+func APIReportDataHandler(w http.ResponseWriter, r *http.Request) {
+	report := findReport(r.PathValue("id"))
+	if report == nil {
+		writeJSONError(w, http.StatusNotFound, "Report not found")
+		return
+	}
+	if !canView(r.Context(), report) {
+		writeJSONError(w, http.StatusForbidden, "Access to this report is denied")
+		return
+	}
+
+	params := r.URL.Query()
+	limit := pool.DefaultPageSize
+	if s := params.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxAPILimit {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxAPILimit))
+			return
+		}
+		limit = n
+	}
+	offset, _ := strconv.Atoi(params.Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), reportTimeout(report))
+	defer cancel()
+	input, fields, valid := requestInput(ctx, report, params)
+	if !valid {
+		errs := make(map[string]string)
+		for _, f := range fields {
+			if f.Error != "" {
+				errs[f.Name] = f.Error
+			}
+		}
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "Invalid report parameters", "parameters": errs})
+		return
+	}
+
+	q, err := buildReportQuery(report, params, input)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if report.ViewType == "pivot" {
+		pivot, err := buildPivot(ctx, report, q.Filtered, q.Args)
+		if err != nil {
+			status, msg := queryError(ctx, err)
+			writeJSONError(w, status, msg)
+			return
+		}
+		writeJSON(w, http.StatusOK, pivot)
+		return
+	}
+
+	// One row beyond the limit tells whether another page exists
+	rows, err := executeOneTimeQuery(ctx, fmt.Sprintf("%s OFFSET %d", q.Page, offset), q.Args, limit+1)
+	if err != nil {
+		status, msg := queryError(ctx, err)
+		writeJSONError(w, status, msg)
+		return
+	}
+	totals, err := computeTotals(ctx, report, q.Filtered, q.Args)
+	if err != nil {
+		status, msg := queryError(ctx, err)
+		writeJSONError(w, status, msg)
+		return
+	}
+
+	data := apiData{
+		Report:  report.ID,
+		Columns: toAPIColumns(q.Columns),
+		Rows:    rows,
+		Totals:  totals,
+		Limit:   limit,
+		Offset:  offset,
+	}
+	if len(data.Rows) > limit {
+		data.Rows = data.Rows[:limit]
+		data.HasMore = true
+	}
+	if data.Rows == nil {
+		data.Rows = []map[string]interface{}{}
+	}
+	writeJSON(w, http.StatusOK, data)
+}
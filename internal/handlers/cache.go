@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// ttlCache keeps values for a fixed time, such as lookups the report page
+// repeats on every render.
+type ttlCache[T any] struct {
+	sync.Mutex
+	ttl time.Duration
+	m   map[string]cacheEntry[T]
+}
+
+type cacheEntry[T any] struct {
+	value   T
+	expires time.Time
+}
+
+func newTTLCache[T any](ttl time.Duration) *ttlCache[T] {
+	return &ttlCache[T]{ttl: ttl, m: make(map[string]cacheEntry[T])}
+}
+
+// cacheKey encodes the parts a cached value depends on.
+func cacheKey(parts ...interface{}) (string, error) {
+	raw, err := json.Marshal(parts)
+	return string(raw), err
+}
+
+func (c *ttlCache[T]) get(key string) (T, bool) {
+	c.Lock()
+	defer c.Unlock()
+	e, ok := c.m[key]
+	if !ok || time.Now().After(e.expires) {
+		var zero T
+		return zero, false
+	}
+	return e.value, true
+}
+
+// put stores value under key, dropping expired entries.
+func (c *ttlCache[T]) put(key string, value T) {
+	c.Lock()
+	defer c.Unlock()
+	now := time.Now()
+	for k, e := range c.m {
+		if now.After(e.expires) {
+			delete(c.m, k)
+		}
+	}
+	c.m[key] = cacheEntry[T]{value: value, expires: now.Add(c.ttl)}
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"GoBI/internal/auth"
 	"GoBI/internal/database"
 	"html/template"
 	"net/http"
@@ -10,8 +11,15 @@ import (
 type DashboardData struct {
 	Stats        []Stat
 	Results      []map[string]interface{}
+	Totals       map[string]interface{}
+	Page         *PageInfo
+	Keyset       *KeysetPage
+	DrillLinks   [][]DrillLink
+	QueryParams  template.URL
+	PageSize     int
 	Columns      []TableColumn
 	DatabaseName string
+	User         *auth.User
 	Year         int
 }
 
@@ -45,6 +53,7 @@ func DashboardHandler(w http.ResponseWriter, r *http.Request) {
 			{Label: "Server Load", Value: "14%", Trend: "Stable", Up: true},
 		},
 		DatabaseName: dbName,
+		User:         auth.UserFrom(r.Context()),
 		Year:         time.Now().Year(),
 	}
 
@@ -60,6 +69,7 @@ func DashboardHandler(w http.ResponseWriter, r *http.Request) {
 		{"ID": "1001", "Status": "Active", "Code": "BIO-01", "Name": "System Alpha", "Latest": "2026-01-19"},
 		{"ID": "1002", "Status": "Pending", "Code": "BIO-02", "Name": "System Beta", "Latest": "2026-01-20"},
 	}
+	data.DrillLinks = make([][]DrillLink, len(data.Results))
 
 	tmpl.Execute(w, data)
 }
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"GoBI/internal/config"
+	"GoBI/internal/database"
+	"fmt"
+	"html/template"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Drill-down parameters: d.<column> filters a child report on a value of the
+// parent row, and every trail value is the id and drill parameters of one
+// report above it, outermost first.
+const (
+	drillPrefix = "d."
+	trailParam  = "trail"
+)
+
+// DrillLink opens a child report filtered on a result row.
+type DrillLink struct {
+	Title string
+	URL   template.URL
+}
+
+// Crumb is a step of the drill path; the current report has no URL.
+type Crumb struct {
+	Title   string
+	URL     template.URL
+	Filters []string
+}
+
+func isDrillParam(key string) bool {
+	return strings.HasPrefix(key, drillPrefix)
+}
+
+// HiddenParam is a request parameter a form passes on unchanged.
+type HiddenParam struct {
+	Name  string
+	Value string
+}
+
+// drillState returns the drill parameters and trail of params, which forms
+// reloading the report have to keep.
+func drillState(params url.Values) []HiddenParam {
+	var keys []string
+	for key := range params {
+		if isDrillParam(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	keys = append(keys, trailParam)
+
+	var state []HiddenParam
+	for _, key := range keys {
+		for _, v := range params[key] {
+			state = append(state, HiddenParam{Name: key, Value: v})
+		}
+	}
+	return state
+}
+
+// isLinkColumn reports whether name is a child column of report's links.
+func isLinkColumn(report *config.Report, name string) bool {
+	for _, link := range report.Links() {
+		if link.Child == name {
+			return true
+		}
+	}
+	return false
+}
+
+// drillConditions filters a child report on the parent row values of its
+// drill parameters. Only the child columns of the report's links are
+// accepted; an empty value matches NULL.
+func drillConditions(report *config.Report, params url.Values, b *database.Binder) ([]string, error) {
+	links := report.Links()
+	var conds []string
+	for key := range params {
+		if !isDrillParam(key) {
+			continue
+		}
+		col := strings.TrimPrefix(key, drillPrefix)
+		if !isLinkColumn(report, col) {
+			return nil, fmt.Errorf("column %q is not a drill-down column", col)
+		}
+	}
+	// Links give the conditions a stable order
+	for _, link := range links {
+		vals, ok := params[drillPrefix+link.Child]
+		if !ok {
+			continue
+		}
+		if vals[0] == "" {
+			conds = append(conds, link.Child+" IS NULL")
+		} else {
+			conds = append(conds, link.Child+" = "+b.Bind(vals[0]))
+		}
+	}
+	return conds, nil
+}
+
+// drillValue renders a row value as a drill parameter that compares equal in
+// SQL, keeping the precision of timestamps.
+func drillValue(v interface{}) string {
+	if t, ok := v.(time.Time); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+	return formatValue(v)
+}
+
+// childReports returns the reports that drill down from report.
+func childReports(rp *config.Repository, report *config.Report) []*config.Report {
+	var children []*config.Report
+	for i := range rp.Reports {
+		if rp.Reports[i].ParentReport == report.ID && len(rp.Reports[i].Links()) > 0 {
+			children = append(children, &rp.Reports[i])
+		}
+	}
+	return children
+}
+
+// trailEntry is the trail value of the current report: its id and drill
+// parameters.
+func trailEntry(report *config.Report, params url.Values) string {
+	entry := url.Values{"id": {report.ID}}
+	for key, vals := range params {
+		if isDrillParam(key) {
+			entry[key] = vals
+		}
+	}
+	return entry.Encode()
+}
+
+// drillLinks returns the links of each result row into the child reports,
+// remembering the view of sessionID they leave. A child is left out for rows
+// that lack one of its parent columns, as grouped rows do.
+func drillLinks(children []*config.Report, results []map[string]interface{}, report *config.Report, params url.Values, sessionID string) [][]DrillLink {
+	trail := append(append([]string{}, params[trailParam]...), trailEntry(report, params))
+	links := make([][]DrillLink, len(results))
+	for i, row := range results {
+		for _, child := range children {
+			target := url.Values{"id": {child.ID}, trailParam: trail, fromParam: {sessionID}}
+			complete := true
+			for _, link := range child.Links() {
+				v, ok := row[link.Parent]
+				if !ok {
+					complete = false
+					break
+				}
+				target.Set(drillPrefix+link.Child, drillValue(v))
+			}
+			if complete {
+				links[i] = append(links[i], DrillLink{Title: child.Title, URL: template.URL("/report?" + target.Encode())})
+			}
+		}
+	}
+	return links
+}
+
+// crumbFilters describes the drill parameters of a report as "label: value".
+func crumbFilters(report *config.Report, params url.Values) []string {
+	var filters []string
+	for _, link := range report.Links() {
+		if vals, ok := params[drillPrefix+link.Child]; ok {
+			val := vals[0]
+			if val == "" {
+				val = "NULL"
+			}
+			filters = append(filters, columnLabel(report, link.Child)+": "+val)
+		}
+	}
+	return filters
+}
+
+// breadcrumb returns the drill path to the current report. The views left
+// in this browser session are preferred, as they restore page and sort;
+// otherwise the trail parameters are used, skipping reports no longer in the
+// repository.
+func breadcrumb(rp *config.Repository, report *config.Report, params url.Values, owner, sessionID string) []Crumb {
+	current := Crumb{Title: report.Title, Filters: crumbFilters(report, params)}
+	if crumbs := viewCrumbs(rp, owner, sessionID); crumbs != nil {
+		return append(crumbs, current)
+	}
+
+	trail := params[trailParam]
+	var crumbs []Crumb
+	for i, entry := range trail {
+		vals, err := url.ParseQuery(entry)
+		if err != nil {
+			continue
+		}
+		ancestor := findIn(rp, vals.Get("id"))
+		if ancestor == nil {
+			continue
+		}
+		target := url.Values{trailParam: trail[:i]}
+		for key, v := range vals {
+			target[key] = v
+		}
+		crumbs = append(crumbs, Crumb{
+			Title:   ancestor.Title,
+			URL:     template.URL("/report?" + target.Encode()),
+			Filters: crumbFilters(ancestor, vals),
+		})
+	}
+	return append(crumbs, current)
+}
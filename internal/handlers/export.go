@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"GoBI/internal/config"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// flushEvery is the number of rows after which an export is flushed to the
+// client while streaming.
+const flushEvery = 1000
+
+// formatParam selects the export format, csv or xlsx.
+const formatParam = "format"
+
+// rowWriter is an export format receiving the report rows as they stream in.
+// types holds the repository column type of each exported column.
+type rowWriter interface {
+	WriteHeader(labels, types []string) error
+	WriteRow(values []interface{}) error
+	WriteTotals(values []interface{}) error
+	// Flush pushes buffered rows to the underlying writer
+	Flush() error
+	Close() error
+	// Abort releases the writer of a failed export without finishing it
+	Abort()
+}
+
+// exportResponse sets the download headers on the first write, so errors
+// raised before any data is written can still be sent as HTTP errors.
+type exportResponse struct {
+	http.ResponseWriter
+	contentType string
+	filename    string
+	started     bool
+}
+
+func (e *exportResponse) Write(p []byte) (int, error) {
+	if !e.started {
+		e.started = true
+		e.Header().Set("Content-Type", e.contentType)
+		e.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", e.filename))
+	}
+	return e.ResponseWriter.Write(p)
+}
+
+func (e *exportResponse) Flush() {
+	if f, ok := e.ResponseWriter.(http.Flusher); ok && e.started {
+		f.Flush()
+	}
+}
+
+type csvWriter struct {
+	out io.Writer
+	w   *csv.Writer
+}
+
+func newCSVWriter(out io.Writer) *csvWriter {
+	return &csvWriter{out: out, w: csv.NewWriter(out)}
+}
+
+func (c *csvWriter) write(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		record[i] = formatValue(v)
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvWriter) WriteHeader(labels, _ []string) error {
+	// The BOM makes Excel open the UTF-8 file with the right encoding
+	if _, err := io.WriteString(c.out, "\ufeff"); err != nil {
+		return err
+	}
+	return c.w.Write(labels)
+}
+
+func (c *csvWriter) WriteRow(values []interface{}) error {
+	return c.write(values)
+}
+
+func (c *csvWriter) WriteTotals(values []interface{}) error {
+	return c.write(values)
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) Close() error {
+	return c.Flush()
+}
+
+func (c *csvWriter) Abort() {}
+
+// formatValue renders a database value as export text.
+func formatValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(val)
+	case time.Time:
+		return val.Format("2006-01-02 15:04:05")
+	}
+	return fmt.Sprint(v)
+}
+
+// ExportHandler streams the full filtered and sorted result of a report, not
+// just the current page, as a downloadable file.
+func ExportHandler(w http.ResponseWriter, r *http.Request) {
+	report := findReport(r.URL.Query().Get("id"))
+	if report == nil {
+		http.Error(w, "Report not found", http.StatusNotFound)
+		return
+	}
+	if !canView(r.Context(), report) {
+		http.Error(w, "Access to this report is denied", http.StatusForbidden)
+		return
+	}
+
+	format := r.URL.Query().Get(formatParam)
+	if format == "" {
+		format = "csv"
+	}
+	var contentType string
+	switch format {
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+	case "xlsx":
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	default:
+		http.Error(w, "Unsupported export format", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	input, _, valid := requestInput(ctx, report, r.URL.Query())
+	if !valid {
+		http.Error(w, "Invalid report parameters", http.StatusBadRequest)
+		return
+	}
+
+	q, err := buildReportQuery(report, r.URL.Query(), input)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	out := &exportResponse{ResponseWriter: w, contentType: contentType, filename: report.ID + "." + format}
+	var rw rowWriter
+	if format == "xlsx" {
+		rw = newXLSXWriter(out, report.Title)
+	} else {
+		rw = newCSVWriter(out)
+	}
+	if report.ViewType == "pivot" {
+		err = exportPivot(ctx, rw, report, q)
+	} else {
+		err = exportRows(ctx, out, rw, report, q)
+	}
+	if err == nil {
+		err = rw.Close()
+	} else {
+		rw.Abort()
+	}
+	if err != nil {
+		if !out.started {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// The response is already streaming, so the error can only be logged
+		log.Printf("Export of %s failed: %v", report.ID, err)
+	}
+}
+
+// exportColumns returns the visible columns of the exported result.
+func exportColumns(q *reportQuery) []config.Column {
+	var cols []config.Column
+	for _, col := range q.Columns {
+		if !col.Hidden {
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+// exportRows streams the rows of q to rw with constant memory, followed by
+// the totals row if the report declares aggregates.
+func exportRows(ctx context.Context, flusher http.Flusher, rw rowWriter, report *config.Report, q *reportQuery) error {
+	totals, err := computeTotals(ctx, report, q.Filtered, q.Args)
+	if err != nil {
+		return err
+	}
+
+	rows, err := pool.GetDB().QueryContext(ctx, q.Page, q.Args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	resultCols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	// Reports without declared columns export everything they select
+	cols := exportColumns(q)
+	if len(q.Columns) == 0 {
+		for _, name := range resultCols {
+			cols = append(cols, config.Column{Name: name})
+		}
+	}
+
+	position := make(map[string]int, len(resultCols))
+	for i, name := range resultCols {
+		position[name] = i
+	}
+	labels := make([]string, len(cols))
+	types := make([]string, len(cols))
+	for i, col := range cols {
+		labels[i] = col.Label
+		if labels[i] == "" {
+			labels[i] = col.Name
+		}
+		types[i] = col.Type
+	}
+	if err := rw.WriteHeader(labels, types); err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(resultCols))
+	dest := make([]interface{}, len(resultCols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	out := make([]interface{}, len(cols))
+
+	for n := 1; rows.Next(); n++ {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		for i, col := range cols {
+			if idx, ok := position[col.Name]; ok {
+				out[i] = values[idx]
+			} else {
+				out[i] = nil
+			}
+		}
+		if err := rw.WriteRow(out); err != nil {
+			return err
+		}
+		if n%flushEvery == 0 {
+			if err := rw.Flush(); err != nil {
+				return err
+			}
+			flusher.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if totals != nil {
+		for i, col := range cols {
+			out[i] = totals[col.Name]
+		}
+		if len(out) > 0 && out[0] == nil {
+			out[0] = "Összesen"
+		}
+		return rw.WriteTotals(out)
+	}
+	return nil
+}
+
+// exportPivot writes the cross-tab of a pivot report.
+func exportPivot(ctx context.Context, rw rowWriter, report *config.Report, q *reportQuery) error {
+	pivot, err := buildPivot(ctx, report, q.Filtered, q.Args)
+	if err != nil {
+		return err
+	}
+
+	labels := append(append(append([]string{}, pivot.RowLabels...), pivot.Columns...), "Összesen")
+	types := make([]string, len(labels))
+	for i := range types {
+		if i < len(pivot.RowLabels) {
+			types[i] = "string"
+		} else {
+			types[i] = pivot.MeasureType
+		}
+	}
+	if err := rw.WriteHeader(labels, types); err != nil {
+		return err
+	}
+	for _, row := range pivot.Rows {
+		out := make([]interface{}, 0, len(labels))
+		for i := range pivot.RowLabels {
+			switch {
+			case i < len(row.Keys):
+				out = append(out, row.Keys[i])
+			case i == len(row.Keys):
+				out = append(out, "Összesen")
+			default:
+				out = append(out, nil)
+			}
+		}
+		out = append(out, row.Cells...)
+		out = append(out, row.Total)
+		if row.GrandTotal {
+			err = rw.WriteTotals(out)
+		} else {
+			err = rw.WriteRow(out)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
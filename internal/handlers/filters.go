@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"GoBI/internal/config"
+	"GoBI/internal/database"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Filter bar parameters are namespaced per column: f.<col> holds the value(s),
+// op.<col> the text match mode, f.<col>.from / f.<col>.to a range.
+const (
+	filterPrefix = "f."
+	opPrefix     = "op."
+)
+
+// maxFilterOptions is the highest number of distinct values for which a text
+// column is offered as a multi-select instead of a free text filter.
+const maxFilterOptions = 25
+
+// The distinct values of a filter column are looked up once per
+// filterOptionsTTL, each lookup bounded by filterOptionsTimeout.
+const (
+	filterOptionsTTL     = 5 * time.Minute
+	filterOptionsTimeout = 5 * time.Second
+)
+
+// filterOptions caches distinct values by report, column and query input.
+var filterOptions = newTTLCache[[]string](filterOptionsTTL)
+
+// FilterField is a filterable column as rendered in the filter bar.
+type FilterField struct {
+	Name     string
+	Label    string
+	Type     string
+	Kind     string // text, range or select
+	Op       string
+	Value    string
+	From     string
+	To       string
+	Options  []string
+	Selected map[string]bool
+}
+
+func isRangeType(colType string) bool {
+	switch colType {
+	case "int", "number", "timestamp", "date":
+		return true
+	}
+	return false
+}
+
+func isFilterParam(key string) bool {
+	return strings.HasPrefix(key, filterPrefix) || strings.HasPrefix(key, opPrefix)
+}
+
+// parseRangeBound converts a range input to the column's type. Dates are
+// entered without time, so the upper bound of a timestamp range is moved to
+// the start of the following day and compared exclusively.
+func parseRangeBound(col *config.Column, val string, upper bool) (interface{}, error) {
+	switch col.Type {
+	case "timestamp", "date":
+		t, err := time.Parse("2006-01-02", val)
+		if err != nil {
+			return nil, err
+		}
+		if upper {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return coerceParam(config.Parameter{Type: col.Type}, val)
+}
+
+// filterConditions turns the filter bar parameters into WHERE conditions,
+// binding their values through b.
+func filterConditions(report *config.Report, params url.Values, b *database.Binder) ([]string, error) {
+	var conds []string
+	for i := range report.Columns {
+		col := &report.Columns[i]
+		key := filterPrefix + col.Name
+		if !col.Filterable {
+			continue
+		}
+
+		if isRangeType(col.Type) {
+			if from := params.Get(key + ".from"); from != "" {
+				val, err := parseRangeBound(col, from, false)
+				if err != nil {
+					return nil, fmt.Errorf("invalid filter value %q for %s", from, col.Name)
+				}
+				conds = append(conds, col.Name+" >= "+b.Bind(val))
+			}
+			if to := params.Get(key + ".to"); to != "" {
+				val, err := parseRangeBound(col, to, true)
+				if err != nil {
+					return nil, fmt.Errorf("invalid filter value %q for %s", to, col.Name)
+				}
+				op := " <= "
+				if col.Type == "timestamp" || col.Type == "date" {
+					op = " < "
+				}
+				conds = append(conds, col.Name+op+b.Bind(val))
+			}
+			continue
+		}
+
+		var values []string
+		for _, v := range params[key] {
+			if v != "" {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		switch params.Get(opPrefix + col.Name) {
+		case "eq":
+			conds = append(conds, col.Name+"::text = "+b.Bind(values[0]))
+		case "contains":
+			conds = append(conds, "strpos(lower("+col.Name+"::text), lower("+b.Bind(values[0])+")) > 0")
+		case "":
+			// Multi-select values
+			conds = append(conds, col.Name+"::text = ANY("+b.BindArray(values)+")")
+		default:
+			return nil, fmt.Errorf("invalid filter operator for %s", col.Name)
+		}
+	}
+	for key := range params {
+		if isFilterParam(key) && !isKnownFilter(report, key) {
+			return nil, fmt.Errorf("column %q is not filterable", key)
+		}
+	}
+	return conds, nil
+}
+
+// isKnownFilter reports whether the filter bar parameter key belongs to a
+// filterable column of the report.
+func isKnownFilter(report *config.Report, key string) bool {
+	name := strings.TrimPrefix(strings.TrimPrefix(key, filterPrefix), opPrefix)
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".from"), ".to")
+	col := findColumn(report, name)
+	return col != nil && col.Filterable
+}
+
+// distinctValues returns up to limit distinct values of column in the
+// report's unfiltered result.
+func distinctValues(ctx context.Context, report *config.Report, input map[string]interface{}, column string, limit int) ([]string, error) {
+	var b database.Binder
+	query := fmt.Sprintf("SELECT DISTINCT %s::text FROM (%s) AS d WHERE %s IS NOT NULL ORDER BY 1 LIMIT %d",
+		column, baseQuery(report, input, &b), column, limit)
+
+	rows, err := pool.GetDB().QueryContext(ctx, query, b.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// filterValues returns distinctValues from the cache, querying them when
+// they are missing or expired.
+func filterValues(ctx context.Context, report *config.Report, input map[string]interface{}, column string, limit int) ([]string, error) {
+	key, err := cacheKey(report.ID, column, limit, input)
+	if err != nil {
+		return nil, err
+	}
+	if values, ok := filterOptions.get(key); ok {
+		return values, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, filterOptionsTimeout)
+	defer cancel()
+	values, err := distinctValues(ctx, report, input, column, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	filterOptions.put(key, values)
+	return values, nil
+}
+
+// buildFilterFields describes the filter bar for the report's filterable
+// columns. Text columns declared with filter "select" become multi-selects
+// when they have few distinct values; when they have more, or their values
+// can't be looked up, they stay text filters.
+func buildFilterFields(ctx context.Context, report *config.Report, input map[string]interface{}, params url.Values) []FilterField {
+	var fields []FilterField
+	for _, col := range report.Columns {
+		if !col.Filterable {
+			continue
+		}
+		key := filterPrefix + col.Name
+		field := FilterField{
+			Name:     col.Name,
+			Label:    col.Label,
+			Type:     col.Type,
+			Kind:     "text",
+			Op:       params.Get(opPrefix + col.Name),
+			Value:    params.Get(key),
+			Selected: make(map[string]bool),
+		}
+		if field.Label == "" {
+			field.Label = col.Name
+		}
+
+		if isRangeType(col.Type) {
+			field.Kind = "range"
+			field.From = params.Get(key + ".from")
+			field.To = params.Get(key + ".to")
+			fields = append(fields, field)
+			continue
+		}
+
+		if col.Filter == "select" && field.Op == "" {
+			values, err := filterValues(ctx, report, input, col.Name, maxFilterOptions+1)
+			if err != nil {
+				log.Printf("Filter values of %s.%s: %v", report.ID, col.Name, err)
+			} else if len(values) <= maxFilterOptions {
+				field.Kind = "select"
+				field.Options = values
+				for _, v := range params[key] {
+					field.Selected[v] = true
+				}
+			}
+		}
+		if field.Op == "" {
+			field.Op = "contains"
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"GoBI/internal/config"
+	"GoBI/internal/dbtest"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// Only columns declared as select filters look up their values, and a
+// failed lookup leaves a text filter.
+func TestBuildFilterFields(t *testing.T) {
+	db := dbtest.Open(func(query string, args []driver.Value) dbtest.Result {
+		if strings.Contains(query, "DISTINCT broken") {
+			return dbtest.Result{Err: errors.New("canceling statement due to statement timeout")}
+		}
+		return dbtest.Result{Columns: []string{"v"}, Rows: [][]driver.Value{{"a"}, {"b"}}}
+	})
+	setTestPool(t, db)
+	report := &config.Report{ID: "filters", TableName: "t", Schema: "s", Columns: []config.Column{
+		{Name: "status", Type: "string", Filterable: true, Filter: "select"},
+		{Name: "name", Type: "string", Filterable: true},
+		{Name: "broken", Type: "string", Filterable: true, Filter: "select"},
+		{Name: "amount", Type: "number", Filterable: true},
+	}}
+
+	fields := buildFilterFields(context.Background(), report, map[string]interface{}{}, url.Values{"f.status": {"b"}})
+	kinds := make(map[string]string)
+	for _, f := range fields {
+		kinds[f.Name] = f.Kind
+	}
+	want := map[string]string{"status": "select", "name": "text", "broken": "text", "amount": "range"}
+	for name, kind := range want {
+		if kinds[name] != kind {
+			t.Errorf("%s is a %s filter, want %s", name, kinds[name], kind)
+		}
+	}
+	if !fields[0].Selected["b"] || len(fields[0].Options) != 2 {
+		t.Errorf("status field %+v", fields[0])
+	}
+	if n := db.Count("SELECT DISTINCT"); n != 2 {
+		t.Errorf("%d distinct lookups, want 2: %v", n, db.Statements())
+	}
+}
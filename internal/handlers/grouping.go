@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"GoBI/internal/config"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// GroupOption is a column offered in the group-by picker.
+type GroupOption struct {
+	Name     string
+	Label    string
+	Selected bool
+}
+
+// groupSelection returns the grouping and measure columns requested with the
+// group and measure parameters of an aggregate report. Measures are the
+// columns declaring an aggregate_func; all of them are used if none is
+// requested.
+func groupSelection(report *config.Report, params url.Values) (groups, measures []config.Column, err error) {
+	if len(params["group"]) == 0 {
+		return nil, nil, nil
+	}
+	if report.ViewType != "aggregate" {
+		return nil, nil, fmt.Errorf("grouping is only available for aggregate reports")
+	}
+
+	for _, name := range params["group"] {
+		col := findColumn(report, name)
+		if col == nil || col.AggregateFunc != "" {
+			return nil, nil, fmt.Errorf("column %q can't be grouped by", name)
+		}
+		groups = append(groups, *col)
+	}
+
+	for _, name := range params["measure"] {
+		col := findColumn(report, name)
+		if col == nil || col.AggregateFunc == "" {
+			return nil, nil, fmt.Errorf("column %q is not a measure", name)
+		}
+		measures = append(measures, *col)
+	}
+	if len(measures) == 0 {
+		for _, col := range report.Columns {
+			if col.AggregateFunc != "" {
+				measures = append(measures, col)
+			}
+		}
+	}
+
+	// Grouped columns are always shown and can be sorted on
+	for i := range groups {
+		groups[i].Hidden = false
+		groups[i].Sortable = true
+	}
+	for i := range measures {
+		measures[i].Hidden = false
+		measures[i].Sortable = true
+	}
+	return groups, measures, nil
+}
+
+// groupedSorts drops the sorts on report columns that are not part of the
+// grouped result, so changing the grouping keeps the remaining sort order.
+func groupedSorts(report *config.Report, cols []config.Column, params url.Values) url.Values {
+	kept := url.Values{}
+	for _, s := range params["sort"] {
+		name := strings.Split(s, ":")[0]
+		shown := false
+		for _, col := range cols {
+			shown = shown || col.Name == name
+		}
+		if shown || findColumn(report, name) == nil {
+			kept.Add("sort", s)
+		}
+	}
+	return kept
+}
+
+func groupColumnList(groups []config.Column) string {
+	names := make([]string, len(groups))
+	for i, col := range groups {
+		names[i] = col.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// groupQuery summarizes query by the grouping columns, aggregating each
+// measure with its declared aggregate_func.
+func groupQuery(groups, measures []config.Column, query string) (string, error) {
+	selects := []string{groupColumnList(groups)}
+	for _, col := range measures {
+		expr, err := aggregateExpr(col.AggregateFunc, col.Name)
+		if err != nil {
+			return "", fmt.Errorf("column %s: %w", col.Name, err)
+		}
+		selects = append(selects, expr+" AS "+col.Name)
+	}
+	return fmt.Sprintf("SELECT %s FROM (%s) AS g GROUP BY %s",
+		strings.Join(selects, ", "), query, groupColumnList(groups)), nil
+}
+
+// groupOptions lists the group-by picker entries of an aggregate report: the
+// grouping candidates and the measures, marked as selected per params.
+func groupOptions(report *config.Report, params url.Values) (groups, measures []GroupOption) {
+	if report.ViewType != "aggregate" {
+		return nil, nil
+	}
+	selected := make(map[string]bool)
+	for _, name := range append(params["group"], params["measure"]...) {
+		selected[name] = true
+	}
+	for _, col := range report.Columns {
+		opt := GroupOption{Name: col.Name, Label: col.Label, Selected: selected[col.Name]}
+		if opt.Label == "" {
+			opt.Label = col.Name
+		}
+		if col.AggregateFunc != "" {
+			measures = append(measures, opt)
+		} else {
+			groups = append(groups, opt)
+		}
+	}
+	if len(measures) == 0 {
+		return nil, nil
+	}
+	return groups, measures
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"GoBI/internal/config"
+	"GoBI/internal/database"
+	"GoBI/internal/dbtest"
+	"os"
+	"testing"
+)
+
+// chdirRoot runs the test from the repository root, where the templates are.
+func chdirRoot(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// setTestPool makes the handlers use a cursor pool on db.
+func setTestPool(t *testing.T, db *dbtest.DB) {
+	old := pool
+	SetPool(database.NewCursorPoolDB(db.DB, config.CursorPoolConfig{MaxConnections: 2, PageSize: 10, AvailablePageSizes: []int{10, 20}}))
+	t.Cleanup(func() { pool = old })
+}
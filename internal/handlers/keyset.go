@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"GoBI/internal/config"
+	"GoBI/internal/database"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// KeysetPage is a page of a keyset paged detail report. Next and Prev are
+// the seek tokens of the neighbouring pages, empty at either end.
+type KeysetPage struct {
+	Rows []map[string]interface{}
+	Next string
+	Prev string
+}
+
+// keysetOrder returns the requested sorts followed by the report's key
+// columns, which make the order total.
+func keysetOrder(report *config.Report, params url.Values) ([]sortSpec, error) {
+	sorts, err := parseSorts(report.Columns, params)
+	if err != nil {
+		return nil, err
+	}
+	sorted := make(map[string]bool, len(sorts))
+	for _, s := range sorts {
+		sorted[s.Column] = true
+	}
+	for _, key := range report.Keys() {
+		if !sorted[key] {
+			sorts = append(sorts, sortSpec{Column: key})
+		}
+	}
+	return sorts, nil
+}
+
+// encodeKeyset turns the order values of row into a seek token, with NULL
+// values as JSON null.
+func encodeKeyset(row map[string]interface{}, order []sortSpec) (string, error) {
+	values := make([]*string, len(order))
+	for i, s := range order {
+		var val string
+		switch v := row[s.Column].(type) {
+		case nil:
+			continue
+		case time.Time:
+			val = v.Format(time.RFC3339Nano)
+		default:
+			val = fmt.Sprint(v)
+		}
+		values[i] = &val
+	}
+	raw, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodeKeyset(token string, n int) ([]*string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid page token")
+	}
+	var values []*string
+	if err := json.Unmarshal(raw, &values); err != nil || len(values) != n {
+		return nil, fmt.Errorf("invalid page token")
+	}
+	return values, nil
+}
+
+// keysetOrderList is orderList with NULLs sorted as the largest values, the
+// Postgres default that seekCondition relies on.
+func keysetOrderList(sorts []sortSpec) string {
+	parts := make([]string, len(sorts))
+	for i, s := range sorts {
+		parts[i] = s.Column + " ASC NULLS LAST"
+		if s.Desc {
+			parts[i] = s.Column + " DESC NULLS FIRST"
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// seekCondition selects the rows after values in order, or before them when
+// backward is set, column by column: (a > x) OR (a = x AND b < y) OR ...
+// NULL sorts as the largest value.
+func seekCondition(order []sortSpec, values []*string, backward bool, b *database.Binder) string {
+	equal := func(col string, v *string) string {
+		if v == nil {
+			return col + " IS NULL"
+		}
+		return col + " = " + b.Bind(*v)
+	}
+	// beyond compares col with v in the seek direction
+	beyond := func(s sortSpec, v *string) string {
+		switch {
+		case v == nil:
+			return s.Column + " IS NOT NULL"
+		case s.Desc != backward:
+			return s.Column + " < " + b.Bind(*v)
+		}
+		return "(" + s.Column + " > " + b.Bind(*v) + " OR " + s.Column + " IS NULL)"
+	}
+
+	var alts []string
+	for i, s := range order {
+		// Nothing follows NULL where it sorts last
+		if values[i] == nil && s.Desc == backward {
+			continue
+		}
+		conds := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			conds = append(conds, equal(order[j].Column, values[j]))
+		}
+		conds = append(conds, beyond(s, values[i]))
+		alts = append(alts, "("+strings.Join(conds, " AND ")+")")
+	}
+	if len(alts) == 0 {
+		return "false"
+	}
+	return "(" + strings.Join(alts, " OR ") + ")"
+}
+
+// fetchKeysetPage returns the page of a detail report after the "after" token
+// or before the "before" token of params, or its first page. Every page is a
+// single stateless query, so no connection is held between requests.
+func fetchKeysetPage(ctx context.Context, report *config.Report, q *reportQuery, params url.Values, pageSize int) (*KeysetPage, error) {
+	order, err := keysetOrder(report, params)
+	if err != nil {
+		return nil, err
+	}
+
+	b := database.Binder{Args: append([]interface{}{}, q.Args...)}
+	query := fmt.Sprintf("SELECT * FROM (%s) AS k", q.Filtered)
+	token, backward := params.Get("after"), false
+	if t := params.Get("before"); t != "" {
+		token, backward = t, true
+	}
+	if token != "" {
+		values, err := decodeKeyset(token, len(order))
+		if err != nil {
+			return nil, err
+		}
+		query += " WHERE " + seekCondition(order, values, backward, &b)
+	}
+
+	// A backward page is read in reverse order and flipped afterwards
+	fetchOrder := order
+	if backward {
+		fetchOrder = make([]sortSpec, len(order))
+		for i, s := range order {
+			fetchOrder[i] = sortSpec{Column: s.Column, Desc: !s.Desc}
+		}
+	}
+	query += " ORDER BY " + keysetOrderList(fetchOrder)
+
+	// One row beyond the page tells whether the result goes on
+	rows, err := executeOneTimeQuery(ctx, query, b.Args, pageSize+1)
+	if err != nil {
+		return nil, err
+	}
+	more := len(rows) > pageSize
+	if more {
+		rows = rows[:pageSize]
+	}
+	if backward {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	page := &KeysetPage{Rows: rows}
+	if len(rows) == 0 {
+		return page, nil
+	}
+	hasNext, hasPrev := more, token != ""
+	if backward {
+		hasNext, hasPrev = true, more
+	}
+	if hasNext {
+		if page.Next, err = encodeKeyset(rows[len(rows)-1], order); err != nil {
+			return nil, err
+		}
+	}
+	if hasPrev {
+		if page.Prev, err = encodeKeyset(rows[0], order); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
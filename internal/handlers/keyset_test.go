@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"GoBI/internal/config"
+	"GoBI/internal/database"
+	"GoBI/internal/dbtest"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSeekCondition(t *testing.T) {
+	x := "x"
+	order := []sortSpec{{Column: "a"}, {Column: "id"}}
+	tests := []struct {
+		name     string
+		order    []sortSpec
+		values   []*string
+		backward bool
+		want     string
+		args     []interface{}
+	}{
+		{"after value", order, []*string{&x, &x}, false,
+			"(((a > $1 OR a IS NULL)) OR (a = $2 AND (id > $3 OR id IS NULL)))", []interface{}{"x", "x", "x"}},
+		{"after NULL", order, []*string{nil, &x}, false,
+			"((a IS NULL AND (id > $1 OR id IS NULL)))", []interface{}{"x"}},
+		{"before NULL", order, []*string{nil, &x}, true,
+			"((a IS NOT NULL) OR (a IS NULL AND id < $1))", []interface{}{"x"}},
+		{"descending after NULL", []sortSpec{{Column: "a", Desc: true}, {Column: "id"}}, []*string{nil, &x}, false,
+			"((a IS NOT NULL) OR (a IS NULL AND (id > $1 OR id IS NULL)))", []interface{}{"x"}},
+		{"nothing after NULL", []sortSpec{{Column: "a"}}, []*string{nil}, false,
+			"false", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b database.Binder
+			if got := seekCondition(tt.order, tt.values, tt.backward, &b); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+			if !reflect.DeepEqual(b.Args, tt.args) {
+				t.Errorf("args %v, want %v", b.Args, tt.args)
+			}
+		})
+	}
+}
+
+func TestKeysetTokenNull(t *testing.T) {
+	order := []sortSpec{{Column: "a"}, {Column: "id"}}
+	token, err := encodeKeyset(map[string]interface{}{"a": nil, "id": int64(3)}, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := decodeKeyset(token, len(order))
+	if err != nil || values[0] != nil || values[1] == nil || *values[1] != "3" {
+		t.Errorf("decoded %v, %v", values, err)
+	}
+}
+
+// TestKeysetPaging pages a detail report forward and back through the
+// report handler.
+func TestKeysetPaging(t *testing.T) {
+	chdirRoot(t)
+	// The table holds ids 1 to 5; the fake database answers the seek
+	// condition on id
+	db := dbtest.Open(func(query string, args []driver.Value) dbtest.Result {
+		if !strings.Contains(query, "FROM vir.details") {
+			return dbtest.Result{}
+		}
+		res := dbtest.Result{Columns: []string{"id", "nev"}}
+		ids := []int64{1, 2, 3, 4, 5}
+		if strings.Contains(query, "ORDER BY id DESC") {
+			ids = []int64{5, 4, 3, 2, 1}
+		}
+		for _, id := range ids {
+			if len(args) > 0 {
+				bound, _ := strconv.ParseInt(args[0].(string), 10, 64)
+				if strings.Contains(query, "id > $1") && id <= bound || strings.Contains(query, "id < $1") && id >= bound {
+					continue
+				}
+			}
+			res.Rows = append(res.Rows, []driver.Value{id, fmt.Sprintf("row %d", id)})
+		}
+		return res
+	})
+	setTestPool(t, db)
+	SetRepository(&config.Repository{Reports: []config.Report{{
+		ID: "details", TableName: "details", Schema: "vir", ViewType: "detail", KeyColumn: "id",
+		Columns: []config.Column{{Name: "id", Type: "int"}, {Name: "nev", Type: "string"}},
+	}}})
+
+	get := func(query string) string {
+		req := httptest.NewRequest("GET", "/report?"+query, nil)
+		req.Header.Set("HX-Request", "true")
+		rec := httptest.NewRecorder()
+		ReportDetailHandler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", query, rec.Code, rec.Body)
+		}
+		return rec.Body.String()
+	}
+	token := func(body, param string) string {
+		m := regexp.MustCompile(param + `=([\w-]+)`).FindStringSubmatch(body)
+		if m == nil {
+			t.Fatalf("no %s link in\n%s", param, body)
+		}
+		return m[1]
+	}
+
+	first := get("id=details&page_size=2")
+	if !strings.Contains(first, "row 2") || strings.Contains(first, "row 3") {
+		t.Fatalf("first page:\n%s", first)
+	}
+	second := get("id=details&page_size=2&after=" + token(first, "after"))
+	if !strings.Contains(second, "row 3") || !strings.Contains(second, "row 4") || strings.Contains(second, "row 2") {
+		t.Fatalf("second page:\n%s", second)
+	}
+	back := get("id=details&page_size=2&before=" + token(second, "before"))
+	if !strings.Contains(back, "row 1") || !strings.Contains(back, "row 2") || strings.Contains(back, "row 3") {
+		t.Fatalf("back to the first page:\n%s", back)
+	}
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"GoBI/internal/config"
+	"html/template"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// navTTL is how long an unvisited view is kept for back-navigation.
+const navTTL = time.Hour
+
+// maxNavDepth caps the navigation stack; older views drop out of the
+// breadcrumb.
+const maxNavDepth = 10
+
+// Navigation parameters: _from names the view a drill-down came from, _back
+// asks to restore a view as it was left. The prefix keeps them apart from
+// report parameters.
+const (
+	fromParam = "_from"
+	backParam = "_back"
+)
+
+// navView is the state of a report tab, keyed by its session ID. The Parent
+// chain is the navigation stack the breadcrumb walks back.
+type navView struct {
+	Owner    string
+	ReportID string
+	// Params are the query shaping parameters: sort, filters, drill
+	// parameters, page size and keyset position
+	Params url.Values
+	// Offset is the first row of the page shown from the report's cursor
+	Offset   int
+	Parent   string
+	LastUsed time.Time
+}
+
+var navViews = struct {
+	sync.Mutex
+	m map[string]*navView
+}{m: make(map[string]*navView)}
+
+// viewParams returns the request parameters that restore a view.
+func viewParams(params url.Values) url.Values {
+	kept := url.Values{}
+	for key, vals := range params {
+		switch key {
+		case "id", "session", "dir", "page", "row", fromParam, backParam:
+			continue
+		}
+		kept[key] = vals
+	}
+	return kept
+}
+
+// recordView stores the state of a report tab after a request. Paging
+// requests only move the offset; a drill-down links the new view to the
+// view it came from, or replaces that view when it shows the same report.
+func recordView(owner, sessionID string, report *config.Report, params url.Values, offset int) {
+	navViews.Lock()
+	defer navViews.Unlock()
+
+	now := time.Now()
+	view, ok := navViews.m[sessionID]
+	if ok && view.Owner != owner {
+		return
+	}
+	if !ok {
+		for id, v := range navViews.m {
+			if now.Sub(v.LastUsed) > navTTL {
+				delete(navViews.m, id)
+			}
+		}
+		view = &navView{Owner: owner}
+		if from, ok := navViews.m[params.Get(fromParam)]; ok && from.Owner == owner {
+			view.Parent = params.Get(fromParam)
+			if from.ReportID == report.ID {
+				view.Parent = from.Parent
+				delete(navViews.m, params.Get(fromParam))
+			}
+		}
+		navViews.m[sessionID] = view
+		trimNavStack(view)
+	}
+
+	paging := params.Get("dir") != "" || params.Get("page") != "" || params.Get("row") != ""
+	if !paging || view.ReportID != report.ID {
+		view.ReportID = report.ID
+		view.Params = viewParams(params)
+	}
+	view.Offset = offset
+	view.LastUsed = now
+}
+
+// trimNavStack cuts the stack below view at maxNavDepth views. The caller
+// holds the navViews lock.
+func trimNavStack(view *navView) {
+	seen := make(map[string]bool)
+	for depth := 1; view.Parent != "" && !seen[view.Parent]; depth++ {
+		if depth == maxNavDepth {
+			view.Parent = ""
+			return
+		}
+		seen[view.Parent] = true
+		parent, ok := navViews.m[view.Parent]
+		if !ok {
+			return
+		}
+		view = parent
+	}
+}
+
+// lookupView returns a copy of the owner's view of sessionID, or nil.
+func lookupView(owner, sessionID string) *navView {
+	navViews.Lock()
+	defer navViews.Unlock()
+	view, ok := navViews.m[sessionID]
+	if !ok || view.Owner != owner {
+		return nil
+	}
+	v := *view
+	return &v
+}
+
+// viewCrumbs returns the breadcrumb of the views above sessionID, each
+// linking back to its page, sort and filters. It returns nil when the view
+// has no recorded parent.
+func viewCrumbs(rp *config.Repository, owner, sessionID string) []Crumb {
+	navViews.Lock()
+	defer navViews.Unlock()
+
+	var crumbs []Crumb
+	view, ok := navViews.m[sessionID]
+	seen := map[string]bool{sessionID: true}
+	for ok && view.Owner == owner && view.Parent != "" && !seen[view.Parent] {
+		id := view.Parent
+		seen[id] = true
+		if view, ok = navViews.m[id]; !ok || view.Owner != owner {
+			break
+		}
+		report := findIn(rp, view.ReportID)
+		if report == nil {
+			break
+		}
+		target := url.Values{"id": {report.ID}, "session": {id}, backParam: {"1"}}
+		for key, vals := range view.Params {
+			target[key] = vals
+		}
+		crumbs = append([]Crumb{{
+			Title:   report.Title,
+			URL:     template.URL("/report?" + target.Encode()),
+			Filters: crumbFilters(report, view.Params),
+		}}, crumbs...)
+	}
+	return crumbs
+}
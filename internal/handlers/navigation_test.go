@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"GoBI/internal/config"
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+func resetNavViews(t *testing.T) {
+	navViews.Lock()
+	old := navViews.m
+	navViews.m = make(map[string]*navView)
+	navViews.Unlock()
+	t.Cleanup(func() {
+		navViews.Lock()
+		navViews.m = old
+		navViews.Unlock()
+	})
+}
+
+// Drilling into the same report replaces its view, and the stack stays
+// within maxNavDepth.
+func TestRecordViewStack(t *testing.T) {
+	resetNavViews(t)
+	rp := &config.Repository{Reports: []config.Report{{ID: "a", Title: "A"}, {ID: "b", Title: "B"}}}
+	report := func(id string) *config.Report { return findIn(rp, id) }
+
+	recordView("u", "s0", report("a"), url.Values{}, 0)
+	recordView("u", "s1", report("b"), url.Values{fromParam: {"s0"}}, 0)
+	recordView("u", "s2", report("b"), url.Values{fromParam: {"s1"}, "d.k": {"x"}}, 0)
+	if lookupView("u", "s1") != nil {
+		t.Error("the replaced view is kept")
+	}
+	if v := lookupView("u", "s2"); v == nil || v.Parent != "s0" {
+		t.Fatalf("view %+v, want s0 as its parent", v)
+	}
+	if crumbs := viewCrumbs(rp, "u", "s2"); len(crumbs) != 1 || crumbs[0].Title != "A" {
+		t.Errorf("crumbs %+v", crumbs)
+	}
+
+	prev := "s2"
+	for i := 0; i < 3*maxNavDepth; i++ {
+		id := fmt.Sprintf("t%d", i)
+		recordView("u", id, report([]string{"a", "b"}[i%2]), url.Values{fromParam: {prev}}, 0)
+		prev = id
+	}
+	if crumbs := viewCrumbs(rp, "u", prev); len(crumbs) != maxNavDepth-1 {
+		t.Errorf("%d crumbs, want %d", len(crumbs), maxNavDepth-1)
+	}
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"GoBI/internal/config"
+	"GoBI/internal/database"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ParamField is a declared report parameter as rendered in the parameter form.
+type ParamField struct {
+	config.Parameter
+	Value   string
+	Options []config.Option
+	Error   string
+}
+
+var timestampLayouts = []string{"2006-01-02T15:04", "2006-01-02T15:04:05", "2006-01-02 15:04:05", time.RFC3339}
+
+// coerceParam converts a submitted value to the Go type of the parameter.
+func coerceParam(p config.Parameter, val string) (interface{}, error) {
+	switch p.Type {
+	case "int":
+		return strconv.ParseInt(val, 10, 64)
+	case "number":
+		return strconv.ParseFloat(val, 64)
+	case "bool":
+		return strconv.ParseBool(val)
+	case "date":
+		return time.Parse("2006-01-02", val)
+	case "timestamp":
+		for _, layout := range timestampLayouts {
+			if t, err := time.Parse(layout, val); err == nil {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("invalid timestamp")
+	case "", "string":
+		return val, nil
+	}
+	return nil, fmt.Errorf("unknown parameter type %q", p.Type)
+}
+
+// Queried parameter options are reused for paramOptionsTTL, each query
+// bounded by paramOptionsTimeout.
+const (
+	paramOptionsTTL     = 5 * time.Minute
+	paramOptionsTimeout = 5 * time.Second
+)
+
+// paramOptions caches queried options by report, parameter and user.
+var paramOptions = newTTLCache[[]config.Option](paramOptionsTTL)
+
+// loadOptions returns the static options of p, or the rows of its
+// options_sql query (value in the first column, optional label in the second).
+// Row filters don't apply to the query; it is rendered with the signed-in
+// user's :current_user and :current_roles to restrict itself.
+func loadOptions(ctx context.Context, report *config.Report, p config.Parameter) ([]config.Option, error) {
+	if p.OptionsSQL == "" {
+		return p.Options, nil
+	}
+	input := withUser(ctx, map[string]interface{}{})
+	key, err := cacheKey(report.ID, p.Name, input)
+	if err != nil {
+		return nil, err
+	}
+	if options, ok := paramOptions.get(key); ok {
+		return options, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, paramOptionsTimeout)
+	defer cancel()
+	options, err := queryOptions(ctx, p, input)
+	if err != nil {
+		return nil, err
+	}
+	paramOptions.put(key, options)
+	return options, nil
+}
+
+func queryOptions(ctx context.Context, p config.Parameter, input map[string]interface{}) ([]config.Option, error) {
+	query, args := database.ProcessSQLArgs(p.OptionsSQL, input)
+	rows, err := pool.GetDB().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, _ := rows.Columns()
+	var options []config.Option
+	for rows.Next() {
+		var opt config.Option
+		if len(cols) > 1 {
+			err = rows.Scan(&opt.Value, &opt.Label)
+		} else {
+			err = rows.Scan(&opt.Value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if opt.Label == "" {
+			opt.Label = opt.Value
+		}
+		options = append(options, opt)
+	}
+	return options, rows.Err()
+}
+
+// parseParameters validates the request against the report's declared
+// parameters. It returns the typed input map for ProcessSQL, the form fields
+// and whether every parameter was valid.
+func parseParameters(ctx context.Context, report *config.Report, params url.Values) (map[string]interface{}, []ParamField, bool) {
+	input := make(map[string]interface{})
+	fields := make([]ParamField, 0, len(report.Parameters))
+	valid := true
+
+	for _, p := range report.Parameters {
+		field := ParamField{Parameter: p, Value: p.Default}
+		if vals, ok := params[p.Name]; ok {
+			field.Value = vals[0]
+		}
+
+		// Without its options a parameter is entered as free text
+		options, err := loadOptions(ctx, report, p)
+		if err != nil {
+			log.Printf("Options of %s.%s: %v", report.ID, p.Name, err)
+		}
+		field.Options = options
+
+		switch {
+		case field.Error != "":
+		case field.Value == "":
+			if p.Required {
+				field.Error = "Required"
+			}
+		case len(options) > 0 && !hasOption(options, field.Value):
+			field.Error = "Not an allowed value"
+		default:
+			val, err := coerceParam(p, field.Value)
+			if err != nil {
+				field.Error = "Invalid " + p.Type
+			} else {
+				input[p.Name] = val
+			}
+		}
+
+		if field.Error != "" {
+			valid = false
+		}
+		fields = append(fields, field)
+	}
+	return input, fields, valid
+}
+
+func hasOption(options []config.Option, val string) bool {
+	for _, opt := range options {
+		if opt.Value == val {
+			return true
+		}
+	}
+	return false
+}
+
+// requestInput returns the ProcessSQL input of a report request: the typed
+// declared parameters, or the raw request parameters if none are declared.
+func requestInput(ctx context.Context, report *config.Report, params url.Values) (map[string]interface{}, []ParamField, bool) {
+	if len(report.Parameters) == 0 {
+		return withUser(ctx, reportInput(params)), nil, true
+	}
+	input, fields, valid := parseParameters(ctx, report, params)
+	return withUser(ctx, input), fields, valid
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"GoBI/internal/config"
+	"GoBI/internal/dbtest"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParameterOptions(t *testing.T) {
+	db := dbtest.Open(func(query string, args []driver.Value) dbtest.Result {
+		if strings.Contains(query, "broken") {
+			return dbtest.Result{Err: errors.New("relation does not exist")}
+		}
+		return dbtest.Result{Columns: []string{"v"}, Rows: [][]driver.Value{{"a"}, {"b"}}}
+	})
+	setTestPool(t, db)
+	report := &config.Report{ID: "options", Parameters: []config.Parameter{
+		{Name: "p", Type: "string", Required: true, OptionsSQL: "SELECT v FROM opts"},
+		{Name: "q", Type: "int", OptionsSQL: "SELECT v FROM broken"},
+	}}
+
+	for i := 0; i < 2; i++ {
+		input, fields, valid := parseParameters(context.Background(), report, url.Values{"p": {"b"}, "q": {"7"}})
+		if !valid || input["p"] != "b" || input["q"] != int64(7) {
+			t.Fatalf("input %v, valid %v, fields %+v", input, valid, fields)
+		}
+		if len(fields[0].Options) != 2 || fields[1].Options != nil {
+			t.Errorf("options %v and %v", fields[0].Options, fields[1].Options)
+		}
+	}
+	if n := db.Count("SELECT v FROM opts"); n != 1 {
+		t.Errorf("options queried %d times, want once", n)
+	}
+
+	if _, fields, valid := parseParameters(context.Background(), report, url.Values{"p": {"c"}}); valid || fields[0].Error == "" {
+		t.Error("a value outside the options is accepted")
+	}
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"GoBI/internal/config"
+	"GoBI/internal/database"
+	"context"
+	"database/sql"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// defaultPivotColumns caps the number of pivot columns if the report doesn't.
+const defaultPivotColumns = 20
+
+// nullLabel stands for a NULL row or column value, which is kept apart from
+// the empty string.
+const nullLabel = "(nincs érték)"
+
+// PivotTable is the cross-tab of a pivot report.
+type PivotTable struct {
+	RowLabels    []string   `json:"row_labels"`
+	ColumnLabel  string     `json:"column_label"`
+	MeasureLabel string     `json:"measure_label"`
+	MeasureType  string     `json:"measure_type"`
+	Columns      []string   `json:"columns"`
+	Rows         []PivotRow `json:"rows"`
+	// Truncated is set when the column dimension had more values than the cap;
+	// the totals still cover all of them
+	Truncated  bool `json:"truncated"`
+	MaxColumns int  `json:"max_columns"`
+}
+
+// PivotRow is a row of the cross-tab. Subtotal rows keep only the first
+// Level row dimensions, the grand total row none of them.
+type PivotRow struct {
+	Keys       []string      `json:"keys"`
+	Level      int           `json:"level"`
+	Subtotal   bool          `json:"subtotal"`
+	GrandTotal bool          `json:"grand_total"`
+	Cells      []interface{} `json:"cells"`
+	Total      interface{}   `json:"total"`
+}
+
+func columnLabel(report *config.Report, name string) string {
+	if col := findColumn(report, name); col != nil && col.Label != "" {
+		return col.Label
+	}
+	return name
+}
+
+// pivotKey tells NULL apart from the empty string in the lookups of values.
+func pivotKey(v sql.NullString) string {
+	if !v.Valid {
+		return "\x00"
+	}
+	return v.String
+}
+
+func pivotLabel(v sql.NullString) string {
+	if !v.Valid {
+		return nullLabel
+	}
+	return v.String
+}
+
+// pivotColumnValues returns the distinct values of the pivot column dimension
+// in the filtered result, at most limit of them.
+func pivotColumnValues(ctx context.Context, column, query string, args []interface{}, limit int) ([]sql.NullString, error) {
+	distinctQuery := fmt.Sprintf("SELECT DISTINCT %s::text FROM (%s) AS d ORDER BY 1 NULLS LAST LIMIT %d", column, query, limit)
+	rows, err := pool.GetDB().QueryContext(ctx, distinctQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []sql.NullString
+	for rows.Next() {
+		var v sql.NullString
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// buildPivot computes the cross-tab of a pivot report over the filtered query.
+// Subtotals and grand totals come from GROUPING SETS, so every aggregate
+// function totals correctly.
+func buildPivot(ctx context.Context, report *config.Report, query string, args []interface{}) (*PivotTable, error) {
+	pv := report.Pivot
+	if pv == nil || len(pv.Rows) == 0 || pv.Column == "" || pv.Measure == "" {
+		return nil, fmt.Errorf("report %s has no complete pivot configuration", report.ID)
+	}
+	for _, name := range append([]string{pv.Column, pv.Measure}, pv.Rows...) {
+		if findColumn(report, name) == nil {
+			return nil, fmt.Errorf("pivot column %q is not declared", name)
+		}
+	}
+	measure := findColumn(report, pv.Measure)
+	aggFunc := measure.AggregateFunc
+	if aggFunc == "" {
+		aggFunc = "sum"
+	}
+	aggExpr, err := aggregateExpr(aggFunc, measure.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	maxColumns := pv.MaxColumns
+	if maxColumns <= 0 {
+		maxColumns = defaultPivotColumns
+	}
+
+	table := &PivotTable{
+		ColumnLabel:  columnLabel(report, pv.Column),
+		MeasureLabel: columnLabel(report, pv.Measure),
+		MeasureType:  measure.Type,
+		MaxColumns:   maxColumns,
+	}
+	for _, name := range pv.Rows {
+		table.RowLabels = append(table.RowLabels, columnLabel(report, name))
+	}
+
+	values, err := pivotColumnValues(ctx, pv.Column, query, args, maxColumns+1)
+	if err != nil {
+		return nil, err
+	}
+	b := database.Binder{Args: append([]interface{}{}, args...)}
+	// The cells of the columns left out are dropped after grouping, so that
+	// the row totals still cover them
+	having := ""
+	if len(values) > maxColumns {
+		values = values[:maxColumns]
+		table.Truncated = true
+		// NULL sorts last, so it is never among the shown values
+		shown := make([]string, len(values))
+		for i, v := range values {
+			shown[i] = v.String
+		}
+		having = fmt.Sprintf("\nHAVING GROUPING(%s) = 1 OR %s::text = ANY(%s)", pv.Column, pv.Column, b.BindArray(shown))
+	}
+	colIndex := make(map[string]int, len(values))
+	for i, v := range values {
+		table.Columns = append(table.Columns, pivotLabel(v))
+		colIndex[pivotKey(v)] = i
+	}
+
+	// One grouping set per prefix of the row dimensions, with and without the
+	// column dimension: (r1..rk, c), (r1..rk), ..., (c), ()
+	rowList := strings.Join(pv.Rows, ", ")
+	var sets, order []string
+	for i := len(pv.Rows); i >= 0; i-- {
+		prefix := strings.Join(pv.Rows[:i], ", ")
+		if prefix == "" {
+			sets = append(sets, "("+pv.Column+")", "()")
+		} else {
+			sets = append(sets, "("+prefix+", "+pv.Column+")", "("+prefix+")")
+		}
+	}
+	for _, name := range pv.Rows {
+		order = append(order, name, "GROUPING("+name+")")
+	}
+	pivotQuery := fmt.Sprintf(`SELECT %s, %s::text, %s, GROUPING(%s), GROUPING(%s)
+FROM (%s) AS p
+GROUP BY GROUPING SETS (%s)%s
+ORDER BY %s`,
+		rowList, pv.Column, aggExpr, rowList, pv.Column, query, strings.Join(sets, ", "), having, strings.Join(order, ", "))
+
+	rows, err := pool.GetDB().QueryContext(ctx, pivotQuery, b.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	nRows := len(pv.Rows)
+	var current *PivotRow
+	var currentKey string
+	for rows.Next() {
+		keys := make([]sql.NullString, nRows)
+		var colVal sql.NullString
+		var val interface{}
+		var rowGrouping, colGrouping int
+		dest := make([]interface{}, 0, nRows+4)
+		for i := range keys {
+			dest = append(dest, &keys[i])
+		}
+		dest = append(dest, &colVal, &val, &rowGrouping, &colGrouping)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if raw, ok := val.([]byte); ok {
+			val = string(raw)
+		}
+
+		level := nRows - bits.OnesCount(uint(rowGrouping))
+		rowKeys := make([]string, level)
+		groupKeys := make([]string, level)
+		for i := range rowKeys {
+			rowKeys[i] = pivotLabel(keys[i])
+			groupKeys[i] = pivotKey(keys[i])
+		}
+		key := fmt.Sprintf("%d\x01%s", level, strings.Join(groupKeys, "\x01"))
+		if current == nil || key != currentKey {
+			table.Rows = append(table.Rows, PivotRow{
+				Keys:       rowKeys,
+				Level:      level,
+				Subtotal:   level > 0 && level < nRows,
+				GrandTotal: level == 0,
+				Cells:      make([]interface{}, len(table.Columns)),
+			})
+			current = &table.Rows[len(table.Rows)-1]
+			currentKey = key
+		}
+
+		if colGrouping == 1 {
+			current.Total = val
+		} else if i, ok := colIndex[pivotKey(colVal)]; ok {
+			current.Cells[i] = val
+		}
+	}
+	return table, rows.Err()
+}
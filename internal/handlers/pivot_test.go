@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"GoBI/internal/config"
+	"GoBI/internal/dbtest"
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A truncated pivot keeps the totals of the columns left out, and NULL keys
+// stay apart from empty strings.
+func TestBuildPivot(t *testing.T) {
+	var pivotQuery string
+	db := dbtest.Open(func(query string, args []driver.Value) dbtest.Result {
+		if strings.HasPrefix(query, "SELECT DISTINCT") {
+			return dbtest.Result{Columns: []string{"v"}, Rows: [][]driver.Value{{""}, {"a"}, {nil}}}
+		}
+		pivotQuery = query
+		// r, c, measure, GROUPING(r), GROUPING(c)
+		return dbtest.Result{
+			Columns: []string{"r", "c", "sum", "gr", "gc"},
+			Rows: [][]driver.Value{
+				{"", "", int64(1), int64(0), int64(0)},
+				{"", "a", int64(2), int64(0), int64(0)},
+				{"", nil, int64(10), int64(0), int64(1)},
+				{nil, "a", int64(3), int64(0), int64(0)},
+				{nil, nil, int64(30), int64(0), int64(1)},
+				{nil, "", int64(1), int64(1), int64(0)},
+				{nil, "a", int64(5), int64(1), int64(0)},
+				{nil, nil, int64(40), int64(1), int64(1)},
+			},
+		}
+	})
+	setTestPool(t, db)
+	report := &config.Report{ID: "pivot", ViewType: "pivot", Columns: []config.Column{
+		{Name: "r", Type: "string"},
+		{Name: "c", Type: "string"},
+		{Name: "amount", Type: "number", AggregateFunc: "sum"},
+	}, Pivot: &config.Pivot{Rows: []string{"r"}, Column: "c", Measure: "amount", MaxColumns: 2}}
+
+	table, err := buildPivot(context.Background(), report, "SELECT * FROM t", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !table.Truncated || !reflect.DeepEqual(table.Columns, []string{"", "a"}) {
+		t.Fatalf("columns %q, truncated %v", table.Columns, table.Truncated)
+	}
+	if !strings.Contains(pivotQuery, "HAVING GROUPING(c) = 1 OR c::text = ANY($1)") || strings.Contains(pivotQuery, "WHERE") {
+		t.Errorf("the left out columns are filtered before grouping:\n%s", pivotQuery)
+	}
+
+	var got [][]interface{}
+	for _, row := range table.Rows {
+		got = append(got, []interface{}{row.Keys, row.Cells, row.Total})
+	}
+	want := [][]interface{}{
+		{[]string{""}, []interface{}{int64(1), int64(2)}, int64(10)},
+		{[]string{nullLabel}, []interface{}{nil, int64(3)}, int64(30)},
+		{[]string{}, []interface{}{int64(1), int64(5)}, int64(40)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rows\n%v\nwant\n%v", got, want)
+	}
+}
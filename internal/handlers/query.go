@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"GoBI/internal/config"
+	"GoBI/internal/database"
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+)
+
+func findColumn(report *config.Report, name string) *config.Column {
+	for i := range report.Columns {
+		if report.Columns[i].Name == name {
+			return &report.Columns[i]
+		}
+	}
+	return nil
+}
+
+// navParams are request parameters that only drive paging of the current
+// result and are not part of the query itself.
+var navParams = paramSet(config.NavParams)
+
+// reservedParams are request parameters consumed by the report handler and
+// never passed to the report's SQL template.
+var reservedParams = paramSet(config.ReservedParams)
+
+func paramSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
+
+// reportInput collects the request parameters for the report's SQL template.
+// Empty values are left out so that their optional blocks are dropped.
+func reportInput(params url.Values) map[string]interface{} {
+	input := make(map[string]interface{})
+	for key, vals := range params {
+		if navParams[key] || reservedParams[key] || isFilterParam(key) || isDrillParam(key) {
+			continue
+		}
+		var nonEmpty []string
+		for _, v := range vals {
+			if v != "" {
+				nonEmpty = append(nonEmpty, v)
+			}
+		}
+		switch len(nonEmpty) {
+		case 0:
+		case 1:
+			input[key] = nonEmpty[0]
+		default:
+			input[key] = nonEmpty
+		}
+	}
+	return input
+}
+
+// queryParams returns the request parameters that shape the query, encoded
+// for appending to paging links.
+func queryParams(params url.Values) template.URL {
+	kept := url.Values{}
+	for key, vals := range params {
+		if !navParams[key] {
+			kept[key] = vals
+		}
+	}
+	return template.URL(kept.Encode())
+}
+
+// baseQuery returns the report's source query: its SQL template rendered with
+// input, or the whole table when the report has no SQL, restricted to the
+// rows the input's user may see.
+func baseQuery(report *config.Report, input map[string]interface{}, b *database.Binder) string {
+	query := "SELECT * FROM " + report.Schema + "." + report.TableName
+	if report.SQL != "" {
+		query = "SELECT * FROM (\n" + database.ProcessSQLBind(report.SQL, input, b) + ") AS q"
+	}
+	if cond := rowFilter(report, input, b); cond != "" {
+		query = "SELECT * FROM (" + query + " WHERE " + cond + ") AS rls"
+	}
+	return query
+}
+
+// buildFilteredQuery assembles the report SELECT from the template input and
+// the request's filter parameters. Column names are only accepted if the
+// report declares them as filterable; filter values are bound through b.
+func buildFilteredQuery(report *config.Report, params url.Values, input map[string]interface{}, b *database.Binder) (string, error) {
+	query := baseQuery(report, input, b)
+
+	var conds []string
+	filterCol := params.Get("filter_col")
+	filterVal := params.Get("filter_val")
+	if filterCol != "" && filterVal != "" {
+		col := findColumn(report, filterCol)
+		// Older drill-down links filter on the declared parent column
+		if col == nil || !(col.Filterable || isLinkColumn(report, col.Name)) {
+			return "", fmt.Errorf("column %q is not filterable", filterCol)
+		}
+		conds = append(conds, col.Name+" = "+b.Bind(filterVal))
+	}
+
+	drillConds, err := drillConditions(report, params, b)
+	if err != nil {
+		return "", err
+	}
+	conds = append(conds, drillConds...)
+
+	filterConds, err := filterConditions(report, params, b)
+	if err != nil {
+		return "", err
+	}
+	conds = append(conds, filterConds...)
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	return query, nil
+}
+
+// sortSpec is a validated sort parameter.
+type sortSpec struct {
+	Column string
+	Desc   bool
+}
+
+// parseSorts validates the request's sort parameters, accepting only sortable
+// columns among cols.
+func parseSorts(cols []config.Column, params url.Values) ([]sortSpec, error) {
+	var sorts []sortSpec
+	for _, s := range params["sort"] {
+		parts := strings.Split(s, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid sort %q", s)
+		}
+		var col *config.Column
+		for i := range cols {
+			if cols[i].Name == parts[0] {
+				col = &cols[i]
+				break
+			}
+		}
+		if col == nil || !col.Sortable {
+			return nil, fmt.Errorf("column %q is not sortable", parts[0])
+		}
+		dir := strings.ToUpper(parts[1])
+		if dir != "ASC" && dir != "DESC" {
+			return nil, fmt.Errorf("invalid sort direction %q", parts[1])
+		}
+		sorts = append(sorts, sortSpec{Column: col.Name, Desc: dir == "DESC"})
+	}
+	return sorts, nil
+}
+
+func orderList(sorts []sortSpec) string {
+	parts := make([]string, len(sorts))
+	for i, s := range sorts {
+		parts[i] = s.Column + " ASC"
+		if s.Desc {
+			parts[i] = s.Column + " DESC"
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// orderClause builds the ORDER BY clause from the request's sort parameters,
+// accepting only sortable columns among cols.
+func orderClause(cols []config.Column, params url.Values) (string, error) {
+	sorts, err := parseSorts(cols, params)
+	if err != nil || len(sorts) == 0 {
+		return "", err
+	}
+	return " ORDER BY " + orderList(sorts), nil
+}
+
+// reportQuery is the SQL of a single report request.
+type reportQuery struct {
+	// Filtered selects the filtered rows; aggregates are computed over it
+	Filtered string
+	// Page is the displayed result: Filtered grouped and ordered as requested
+	Page    string
+	Args    []interface{}
+	Columns []config.Column
+	Grouped bool
+}
+
+// buildReportQuery builds the filtered, optionally grouped and ordered query
+// of a report request.
+func buildReportQuery(report *config.Report, params url.Values, input map[string]interface{}) (*reportQuery, error) {
+	var b database.Binder
+	filtered, err := buildFilteredQuery(report, params, input, &b)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &reportQuery{Filtered: filtered, Page: filtered, Columns: report.Columns}
+
+	groups, measures, err := groupSelection(report, params)
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) > 0 {
+		q.Grouped = true
+		q.Columns = append(groups, measures...)
+		if q.Page, err = groupQuery(groups, measures, filtered); err != nil {
+			return nil, err
+		}
+	}
+
+	sortParams := params
+	if q.Grouped {
+		sortParams = groupedSorts(report, q.Columns, params)
+	}
+	order, err := orderClause(q.Columns, sortParams)
+	if err != nil {
+		return nil, err
+	}
+	if order == "" && q.Grouped {
+		order = " ORDER BY " + groupColumnList(groups)
+	}
+	q.Page += order
+	q.Args = b.Args
+	return q, nil
+}
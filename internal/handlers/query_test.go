@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"GoBI/internal/config"
+	"GoBI/internal/database"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// The parameters the handlers consume must be the ones config reserves.
+func TestRequestParamsReserved(t *testing.T) {
+	names := []string{
+		fromParam, backParam, savedViewParam, trailParam, formatParam,
+		filterPrefix + "x", opPrefix + "x", drillPrefix + "x",
+	}
+	for _, name := range names {
+		if !config.IsRequestParam(name) {
+			t.Errorf("%q is not reserved", name)
+		}
+	}
+	for _, name := range append(append([]string{}, config.NavParams...), config.ReservedParams...) {
+		repo := &config.Repository{Reports: []config.Report{{
+			ID:         "r",
+			TableName:  "t",
+			Parameters: []config.Parameter{{Name: name, Type: "string"}},
+		}}}
+		if len(repo.Problems()) == 0 {
+			t.Errorf("parameter name %q is accepted", name)
+		}
+	}
+}
+
+// Only report parameters reach the SQL template.
+func TestReportInput(t *testing.T) {
+	params := url.Values{
+		"id": {"r"}, "format": {"csv"}, "sort": {"a:asc"}, "f.a": {"x"}, "d.b": {"y"},
+		config.CurrentUserParam: {"admin"}, "year": {"2024"}, "empty": {""}, "list": {"p", "q"},
+	}
+	want := map[string]interface{}{"year": "2024", "list": []string{"p", "q"}}
+	if got := reportInput(params); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+var testReport = &config.Report{
+	ID:            "child",
+	SQL:           "SELECT * FROM t\nWHERE region = ANY(:regions)\n  AND y = :year\n",
+	ParentColumns: []config.ColumnLink{{Parent: "pid", Child: "parent_id"}, {Parent: "pk", Child: "kind"}},
+	Columns: []config.Column{
+		{Name: "name", Type: "string", Filterable: true, Sortable: true},
+		{Name: "amount", Type: "number", Filterable: true},
+		{Name: "secret", Type: "string"},
+		{Name: "parent_id", Type: "int"},
+		{Name: "kind", Type: "string"},
+	},
+}
+
+func TestParseSorts(t *testing.T) {
+	tests := []struct {
+		sort    []string
+		want    []sortSpec
+		wantErr string
+	}{
+		{[]string{"name:asc"}, []sortSpec{{Column: "name"}}, ""},
+		{[]string{"name:DESC"}, []sortSpec{{Column: "name", Desc: true}}, ""},
+		{[]string{"amount:asc"}, nil, `column "amount" is not sortable`},
+		{[]string{"missing:asc"}, nil, `column "missing" is not sortable`},
+		{[]string{"name:up"}, nil, `invalid sort direction "up"`},
+		{[]string{"name:asc;drop"}, nil, `invalid sort direction "asc;drop"`},
+		{[]string{"name"}, nil, `invalid sort "name"`},
+		{[]string{"name:asc:x"}, nil, `invalid sort "name:asc:x"`},
+	}
+	for _, tt := range tests {
+		got, err := parseSorts(testReport.Columns, url.Values{"sort": tt.sort})
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("%v: err = %v, want %q", tt.sort, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got %v, %v", tt.sort, got, err)
+		}
+	}
+}
+
+func TestBuildFilteredQueryRejects(t *testing.T) {
+	tests := []struct {
+		params  url.Values
+		wantErr string
+	}{
+		{url.Values{"filter_col": {"secret"}, "filter_val": {"x"}}, `column "secret" is not filterable`},
+		{url.Values{"filter_col": {"1=1; --"}, "filter_val": {"x"}}, `column "1=1; --" is not filterable`},
+		{url.Values{"f.secret": {"x"}}, `column "f.secret" is not filterable`},
+		{url.Values{"op.secret": {"eq"}}, `column "op.secret" is not filterable`},
+		{url.Values{"f.name": {"x"}, "op.name": {"like"}}, "invalid filter operator for name"},
+		{url.Values{"f.amount.from": {"many"}}, `invalid filter value "many" for amount`},
+		{url.Values{"d.secret": {"x"}}, `column "secret" is not a drill-down column`},
+	}
+	for _, tt := range tests {
+		var b database.Binder
+		_, err := buildFilteredQuery(testReport, tt.params, map[string]interface{}{"regions": []string{"a"}, "year": 2024}, &b)
+		if err == nil || err.Error() != tt.wantErr {
+			t.Errorf("%v: err = %v, want %q", tt.params, err, tt.wantErr)
+		}
+	}
+}
+
+// Placeholders are numbered across the SQL template, the drill-down and the
+// filter bar conditions in the order of their arguments.
+func TestBuildFilteredQueryPlaceholders(t *testing.T) {
+	params := url.Values{
+		"filter_col":    {"name"},
+		"filter_val":    {"n"},
+		"d.parent_id":   {"7"},
+		"d.kind":        {""},
+		"f.name":        {"a", "b"},
+		"f.amount.from": {"10"},
+		"f.amount.to":   {"20"},
+	}
+	input := map[string]interface{}{"regions": []string{"north", "south"}, "year": int64(2024)}
+	var b database.Binder
+	got, err := buildFilteredQuery(testReport, params, input, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "SELECT * FROM (\nSELECT * FROM t\nWHERE region = ANY($1)\n  AND y = $2\n) AS q" +
+		" WHERE name = $3 AND parent_id = $4 AND kind IS NULL" +
+		" AND name::text = ANY($5) AND amount >= $6 AND amount <= $7"
+	if got != want {
+		t.Errorf("query\n%s\nwant\n%s", got, want)
+	}
+	wantArgs := []interface{}{
+		pq.Array([]string{"north", "south"}), int64(2024), "n", "7",
+		pq.Array([]string{"a", "b"}), float64(10), float64(20),
+	}
+	if !reflect.DeepEqual(b.Args, wantArgs) {
+		t.Errorf("args %#v\nwant %#v", b.Args, wantArgs)
+	}
+}
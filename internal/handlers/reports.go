@@ -1,27 +1,91 @@
 package handlers
 
 import (
+	"GoBI/internal/auth"
 	"GoBI/internal/config"
+	"GoBI/internal/database"
 	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
 	"strconv"
-	"strings"
+	"sync/atomic"
 	"time"
+
+	"github.com/lib/pq"
 )
 
-var repo *config.Repository
+// repo is swapped atomically when the repository file is reloaded
+var repo atomic.Pointer[config.Repository]
+var repoStatus atomic.Pointer[RepositoryStatus]
 var dbName string
 
+// RepositoryStatus is the outcome of the latest repository (re)load.
+type RepositoryStatus struct {
+	LoadedAt time.Time
+	// Error is set when a later reload failed and LoadedAt's version is kept
+	Error    string
+	FailedAt time.Time
+}
+
+// defaultQueryTimeout limits the queries of reports without a timeout.
+const defaultQueryTimeout = 10 * time.Second
+
 func SetRepository(r *config.Repository) {
-	repo = r
+	repo.Store(r)
+	repoStatus.Store(&RepositoryStatus{LoadedAt: time.Now()})
+}
+
+// SetRepositoryError records a failed repository reload. The repository in
+// use is kept.
+func SetRepositoryError(err error) {
+	status := repositoryStatus()
+	status.Error = err.Error()
+	status.FailedAt = time.Now()
+	repoStatus.Store(&status)
+}
+
+// repository returns the current repository, an empty one if none loaded.
+// Handlers take it once per request, so a reload never splits a request.
+func repository() *config.Repository {
+	if r := repo.Load(); r != nil {
+		return r
+	}
+	return &config.Repository{}
+}
+
+func repositoryStatus() RepositoryStatus {
+	if s := repoStatus.Load(); s != nil {
+		return *s
+	}
+	return RepositoryStatus{}
 }
 
 func SetDatabaseName(name string) {
 	dbName = name
 }
 
+func findReport(id string) *config.Report {
+	return findIn(repository(), id)
+}
+
+func findIn(rp *config.Repository, id string) *config.Report {
+	for i := range rp.Reports {
+		if rp.Reports[i].ID == id {
+			return &rp.Reports[i]
+		}
+	}
+	return nil
+}
+
+// isMainReport reports whether a report is listed on its own rather than
+// reached by drilling down: aggregates and pivots.
+func isMainReport(report *config.Report) bool {
+	return report.ViewType == "aggregate" || report.ViewType == "pivot"
+}
+
 func ReportsHandler(w http.ResponseWriter, r *http.Request) {
 	tmpl := template.Must(template.ParseFiles(
 		"ui/templates/reports.html",
@@ -36,10 +100,11 @@ func ReportsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
 
-	// Only show main aggregate reports in the panes
+	// Only show the main aggregate reports the user may open in the panes
+	rp := repository()
 	var aggregateReports []config.Report
-	for _, report := range repo.Reports {
-		if report.ViewType == "aggregate" {
+	for _, report := range rp.Reports {
+		if isMainReport(&report) && canView(r.Context(), &report) {
 			aggregateReports = append(aggregateReports, report)
 		}
 	}
@@ -76,6 +141,7 @@ func ReportsHandler(w http.ResponseWriter, r *http.Request) {
 		Name         string
 		Reports      []config.Report
 		DatabaseName string
+		User         *auth.User
 		Year         int
 		Limit        int
 		NextLimit    int
@@ -87,10 +153,12 @@ func ReportsHandler(w http.ResponseWriter, r *http.Request) {
 		HasNext      bool
 		CurrentPage  int
 		TotalPages   int
+		Status       RepositoryStatus
 	}{
-		Name:         repo.Meta.Name,
+		Name:         rp.Meta.Name,
 		Reports:      pagedReports,
 		DatabaseName: dbName,
+		User:         auth.UserFrom(r.Context()),
 		Year:         time.Now().Year(),
 		Limit:        limit,
 		NextLimit:    nextPageSize,
@@ -102,6 +170,7 @@ func ReportsHandler(w http.ResponseWriter, r *http.Request) {
 		HasNext:      offset+limit < total,
 		CurrentPage:  (offset / limit) + 1,
 		TotalPages:   (total + limit - 1) / limit,
+		Status:       repositoryStatus(),
 	}
 
 	if r.Header.Get("HX-Request") == "true" {
@@ -119,60 +188,70 @@ func ReportDetailHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var selectedReport *config.Report
-	for i := range repo.Reports {
-		if repo.Reports[i].ID == reportID {
-			selectedReport = &repo.Reports[i]
-			break
-		}
-	}
-
+	rp := repository()
+	selectedReport := findIn(rp, reportID)
 	if selectedReport == nil {
 		http.Error(w, "Report not found", http.StatusNotFound)
 		return
 	}
+	if !canView(r.Context(), selectedReport) {
+		http.Error(w, "Access to this report is denied", http.StatusForbidden)
+		return
+	}
+
+	// A bare saved view link, as shared, opens the report with the view's
+	// settings
+	token := browserSession(w, r)
+	user := currentUser(r, token)
+	if len(r.URL.Query()) == 2 && r.URL.Query().Has(savedViewParam) {
+		id, _ := strconv.ParseInt(r.URL.Query().Get(savedViewParam), 10, 64)
+		view, err := database.LoadView(r.Context(), pool.GetDB(), id, user)
+		if err != nil || view.ReportID != selectedReport.ID {
+			http.Error(w, "Saved view not found", http.StatusNotFound)
+			return
+		}
+		if target := viewURL(view); target != "/report?"+r.URL.Query().Encode() {
+			http.Redirect(w, r, target, http.StatusSeeOther)
+			return
+		}
+	}
 
 	tmpl := template.Must(template.ParseFiles(
 		"ui/templates/report_detail.html",
 		"ui/templates/partials/nav.html",
 		"ui/templates/partials/header.html",
 		"ui/templates/partials/table.html",
+		"ui/templates/partials/pivot.html",
 		"ui/templates/partials/footer.html",
 	))
 
-	query := "SELECT * FROM " + selectedReport.Schema + "." + selectedReport.TableName
-	filterCol := r.URL.Query().Get("filter_col")
-	filterVal := r.URL.Query().Get("filter_val")
+	// Queries stop when the browser goes away or the report times out
+	ctx, cancel := context.WithTimeout(r.Context(), reportTimeout(selectedReport))
+	defer cancel()
 
-	if filterCol != "" && filterVal != "" {
-		// Basic SQL injection prevention for values (though this is internal use mostly)
-		query += fmt.Sprintf(" WHERE %s = '%s'", filterCol, filterVal)
-	}
+	input, paramFields, paramsValid := requestInput(ctx, selectedReport, r.URL.Query())
 
-	sorts := r.URL.Query()["sort"]
-	if len(sorts) > 0 {
-		var orderParts []string
-		for _, s := range sorts {
-			parts := strings.Split(s, ":")
-			if len(parts) == 2 {
-				orderParts = append(orderParts, fmt.Sprintf("%s %s", parts[0], parts[1]))
-			}
-		}
-		if len(orderParts) > 0 {
-			query += " ORDER BY " + strings.Join(orderParts, ", ")
-		}
+	q, err := buildReportQuery(selectedReport, r.URL.Query(), input)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	// The filter bar is part of the page, table refreshes don't need it
+	var filterFields []FilterField
+	if paramsValid && r.Header.Get("HX-Request") != "true" {
+		filterFields = buildFilterFields(ctx, selectedReport, input, r.URL.Query())
+	}
 
 	var results []map[string]interface{}
-	var err error
 
+	// Cursors are owned by the browser session and its user; sessionID names
+	// the cursor of one report tab
+	owner := sessionOwner(r, token)
 	direction := r.URL.Query().Get("dir")
 	sessionID := r.URL.Query().Get("session")
 	if sessionID == "" {
-		sessionID = "sess-" + time.Now().Format("05.000000") // Unique-ish session ID
+		sessionID = auth.NewToken()
 	}
 
 	pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
@@ -180,26 +259,91 @@ func ReportDetailHandler(w http.ResponseWriter, r *http.Request) {
 		pageSize = pool.DefaultPageSize
 	}
 
-	if selectedReport.ViewType == "aggregate" {
-		// Use cursorpool for aggregate tables
-		if direction != "" {
-			results, err = pool.FetchPage(ctx, sessionID, direction)
+	// Until the declared parameters are valid only the parameter form is shown
+	var totals map[string]interface{}
+	var pivot *PivotTable
+	var page *PageInfo
+	var offset int
+	var keyset *KeysetPage
+	if paramsValid {
+		if selectedReport.ViewType == "pivot" {
+			pivot, err = buildPivot(ctx, selectedReport, q.Filtered, q.Args)
+		} else if selectedReport.ViewType == "aggregate" {
+			// Use cursorpool for aggregate tables
+			// Going back to a view reuses its cursor while it lives, and
+			// otherwise reopens it at the page that was left
+			var view *navView
+			if r.URL.Query().Get(backParam) != "" {
+				view = lookupView(owner, sessionID)
+				// A view changed since, e.g. sorted again, is queried anew
+				if view != nil && view.Params.Encode() != viewParams(r.URL.Query()).Encode() {
+					view = nil
+				}
+			}
+			if offset, ok := jumpOffset(r.URL.Query(), owner, sessionID); ok {
+				results, err = pool.FetchAt(ctx, owner, sessionID, offset)
+				totals = pool.Totals(owner, sessionID)
+			} else if direction != "" {
+				results, err = pool.FetchPage(ctx, owner, sessionID, direction)
+				totals = pool.Totals(owner, sessionID)
+			} else if view != nil && pool.HasCursor(owner, sessionID) {
+				results, err = pool.FetchAt(ctx, owner, sessionID, view.Offset)
+				totals = pool.Totals(owner, sessionID)
+			} else {
+				results, err = openCursor(ctx, selectedReport, q, owner, sessionID, pageSize)
+				if err == nil {
+					totals, err = computeTotals(ctx, selectedReport, q.Filtered, q.Args)
+					pool.SetTotals(owner, sessionID, totals)
+				}
+				if err == nil && view != nil && view.Offset > 0 {
+					results, err = pool.FetchAt(ctx, owner, sessionID, view.Offset)
+				}
+			}
+			if err == nil {
+				var cursorPageSize int
+				var rowCount int64
+				offset, cursorPageSize, rowCount, err = pool.Position(owner, sessionID)
+				if err == nil {
+					page = newPageInfo(offset, rowCount, cursorPageSize, len(results))
+				}
+			}
 		} else {
-			results, err = pool.ExecuteQuery(ctx, sessionID, query, pageSize, nil)
+			// Use one-time query for detail tables, seeking by key if declared
+			if len(selectedReport.Keys()) > 0 {
+				keyset, err = fetchKeysetPage(ctx, selectedReport, q, r.URL.Query(), pageSize)
+				if err == nil {
+					results = keyset.Rows
+				}
+			} else {
+				results, err = executeOneTimeQuery(ctx, q.Page, q.Args, pageSize)
+			}
+			if err == nil {
+				totals, err = computeTotals(ctx, selectedReport, q.Filtered, q.Args)
+			}
 		}
-	} else {
-		// Use one-time query for detail tables
-		results, err = executeOneTimeQuery(ctx, query, pageSize)
 	}
 
+	switch {
+	case errors.Is(err, database.ErrSessionOwner):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, database.ErrNoSession):
+		http.Error(w, "The report has expired, please reload it", http.StatusGone)
+		return
+	case errors.Is(err, database.ErrPoolExhausted):
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		status, msg := queryError(ctx, err)
+		http.Error(w, msg, status)
 		return
 	}
 
 	var columns []TableColumn
-	for _, col := range selectedReport.Columns {
-		columns = append(columns, TableColumn{Name: col.Name, Label: col.Label, Hidden: col.Hidden})
+	for _, col := range q.Columns {
+		columns = append(columns, TableColumn{Name: col.Name, Label: col.Label, Hidden: col.Hidden, Sortable: col.Sortable, AggregateFunc: col.AggregateFunc})
 	}
 	// If columns not defined in repo, extract from results
 	if len(columns) == 0 && len(results) > 0 {
@@ -208,15 +352,18 @@ func ReportDetailHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Find child report if any
-	var childReportID string
-	var childParentColumn string
-	for _, rpt := range repo.Reports {
-		if rpt.ParentReport == selectedReport.ID {
-			childReportID = rpt.ID
-			childParentColumn = rpt.ParentColumn
-			break
-		}
+	// Rows link to every child report whose parent columns they hold
+	drill := drillLinks(visibleReports(r.Context(), childReports(rp, selectedReport)), results, selectedReport, r.URL.Query(), sessionID)
+
+	recordView(owner, sessionID, selectedReport, r.URL.Query(), offset)
+
+	groupBy, measures := groupOptions(selectedReport, r.URL.Query())
+
+	// The saved view selector is part of the page
+	var savedViews []savedView
+	var currentView *savedView
+	if r.Header.Get("HX-Request") != "true" {
+		savedViews, currentView = pageViews(r.Context(), selectedReport, user, r.URL.Query())
 	}
 
 	// Calculate NextPageSize for cycling
@@ -231,9 +378,9 @@ func ReportDetailHandler(w http.ResponseWriter, r *http.Request) {
 	// Find prev/next aggregate reports for header navigation
 	var aggregateReports []*config.Report
 	var currentIndex = -1
-	for i := range repo.Reports {
-		rpt := &repo.Reports[i]
-		if rpt.ViewType == "aggregate" {
+	for i := range rp.Reports {
+		rpt := &rp.Reports[i]
+		if isMainReport(rpt) && canView(r.Context(), rpt) {
 			aggregateReports = append(aggregateReports, rpt)
 			if rpt.ID == selectedReport.ID {
 				currentIndex = len(aggregateReports) - 1
@@ -250,36 +397,72 @@ func ReportDetailHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := struct {
-		Report            *config.Report
-		Results           []map[string]interface{}
-		Columns           []TableColumn
-		DatabaseName      string
-		Year              int
-		SessionID         string
-		PageSize          int
-		NextPageSize      int
-		PageSizes         []int
-		ChildReportID     string
-		ChildParentColumn string
-		PrevReportID      string
-		NextReportID      string
+		Report       *config.Report
+		Results      []map[string]interface{}
+		Totals       map[string]interface{}
+		Pivot        *PivotTable
+		Page         *PageInfo
+		Keyset       *KeysetPage
+		Columns      []TableColumn
+		DatabaseName string
+		User         *auth.User
+		Year         int
+		SessionID    string
+		QueryParams  template.URL
+		Parameters   []ParamField
+		Filters      []FilterField
+		Sorts        []string
+		DrillState   []HiddenParam
+		GroupBy      []GroupOption
+		Measures     []GroupOption
+		FilterCol    string
+		FilterVal    string
+		PageSize     int
+		NextPageSize int
+		PageSizes    []int
+		DrillLinks   [][]DrillLink
+		Breadcrumb   []Crumb
+		SavedViews   []savedView
+		CurrentView  *savedView
+		PrevReportID string
+		NextReportID string
 	}{
-		Report:            selectedReport,
-		Results:           results,
-		Columns:           columns,
-		DatabaseName:      dbName,
-		Year:              time.Now().Year(),
-		SessionID:         sessionID,
-		PageSize:          pageSize,
-		NextPageSize:      nextPageSize,
-		PageSizes:         pool.AvailablePageSizes,
-		ChildReportID:     childReportID,
-		ChildParentColumn: childParentColumn,
-		PrevReportID:      prevReportID,
-		NextReportID:      nextReportID,
+		Report:       selectedReport,
+		Results:      results,
+		Totals:       totals,
+		Pivot:        pivot,
+		Page:         page,
+		Keyset:       keyset,
+		Columns:      columns,
+		DatabaseName: dbName,
+		User:         auth.UserFrom(r.Context()),
+		Year:         time.Now().Year(),
+		SessionID:    sessionID,
+		QueryParams:  queryParams(r.URL.Query()),
+		Parameters:   paramFields,
+		Filters:      filterFields,
+		Sorts:        r.URL.Query()["sort"],
+		DrillState:   drillState(r.URL.Query()),
+		GroupBy:      groupBy,
+		Measures:     measures,
+		FilterCol:    r.URL.Query().Get("filter_col"),
+		FilterVal:    r.URL.Query().Get("filter_val"),
+		PageSize:     pageSize,
+		NextPageSize: nextPageSize,
+		PageSizes:    pool.AvailablePageSizes,
+		DrillLinks:   drill,
+		Breadcrumb:   breadcrumb(rp, selectedReport, r.URL.Query(), owner, sessionID),
+		SavedViews:   savedViews,
+		CurrentView:  currentView,
+		PrevReportID: prevReportID,
+		NextReportID: nextReportID,
 	}
 
 	if r.Header.Get("HX-Request") == "true" {
+		if pivot != nil {
+			tmpl.ExecuteTemplate(w, "pivot", data)
+			return
+		}
 		tmpl.ExecuteTemplate(w, "table", data)
 		return
 	}
@@ -287,8 +470,106 @@ func ReportDetailHandler(w http.ResponseWriter, r *http.Request) {
 	tmpl.Execute(w, data)
 }
 
-func executeOneTimeQuery(ctx context.Context, query string, limit int) ([]map[string]interface{}, error) {
-	rows, err := pool.GetDB().QueryContext(ctx, fmt.Sprintf("%s LIMIT %d", query, limit))
+// reportTimeout returns the configured query timeout of report.
+func reportTimeout(report *config.Report) time.Duration {
+	if d, err := time.ParseDuration(report.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return defaultQueryTimeout
+}
+
+// queryError maps a failed report query to an HTTP status and message:
+// timeouts and canceled queries are reported as such.
+func queryError(ctx context.Context, err error) (int, string) {
+	var pqErr *pq.Error
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "The report timed out"
+	case errors.As(err, &pqErr) && pqErr.Code == "57014":
+		return http.StatusConflict, "The report was canceled"
+	}
+	return http.StatusInternalServerError, err.Error()
+}
+
+// CancelHandler cancels the running cursor query of a report tab.
+func CancelHandler(w http.ResponseWriter, r *http.Request) {
+	owner := sessionOwner(r, browserSession(w, r))
+	signaled, err := pool.Cancel(r.Context(), owner, r.URL.Query().Get("session"))
+	switch {
+	case errors.Is(err, database.ErrSessionOwner):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, database.ErrNoSession):
+		http.Error(w, "No running query", http.StatusNotFound)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	case !signaled:
+		http.Error(w, "The report's query is not running", http.StatusConflict)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// jumpOffset returns the row offset requested by the 1-based page or row
+// parameter of a paging request.
+func jumpOffset(params url.Values, owner, sessionID string) (int, bool) {
+	if params.Get("session") == "" {
+		return 0, false
+	}
+	if n, err := strconv.Atoi(params.Get("row")); err == nil && n > 0 {
+		return n - 1, true
+	}
+	if n, err := strconv.Atoi(params.Get("page")); err == nil && n > 0 {
+		// Without the cursor the fetch reports the expired report
+		_, pageSize, _, err := pool.Position(owner, sessionID)
+		if err != nil {
+			return 0, true
+		}
+		return (n - 1) * pageSize, true
+	}
+	return 0, false
+}
+
+// openCursor declares the cursor of a report query and counts its rows the
+// way the report is configured to.
+func openCursor(ctx context.Context, report *config.Report, q *reportQuery, owner, sessionID string, pageSize int) ([]map[string]interface{}, error) {
+	switch report.RowCount {
+	case "none":
+		return pool.ExecuteQuery(ctx, owner, sessionID, q.Page, pageSize, q.Args)
+	case "count":
+		type countResult struct {
+			n   int64
+			err error
+		}
+		counted := make(chan countResult, 1)
+		go func() {
+			var res countResult
+			res.err = pool.GetDB().QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM (%s) AS c", q.Filtered), q.Args...).Scan(&res.n)
+			counted <- res
+		}()
+		results, err := pool.ExecuteQuery(ctx, owner, sessionID, q.Page, pageSize, q.Args)
+		res := <-counted
+		if err != nil {
+			return nil, err
+		}
+		if res.err != nil {
+			return nil, res.err
+		}
+		pool.SetRowCount(owner, sessionID, res.n)
+		return results, nil
+	}
+
+	results, err := pool.ExecuteQuery(ctx, owner, sessionID, q.Page, pageSize, q.Args)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := pool.CountRows(ctx, owner, sessionID); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func executeOneTimeQuery(ctx context.Context, query string, args []interface{}, limit int) ([]map[string]interface{}, error) {
+	rows, err := pool.GetDB().QueryContext(ctx, fmt.Sprintf("%s LIMIT %d", query, limit), args...)
 	if err != nil {
 		return nil, err
 	}
@@ -315,5 +596,5 @@ func executeOneTimeQuery(ctx context.Context, query string, limit int) ([]map[st
 		}
 		results = append(results, row)
 	}
-	return results, nil
+	return results, rows.Err()
 }
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"GoBI/internal/auth"
+	"GoBI/internal/config"
+	"GoBI/internal/database"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// savedViewParam opens a report with the settings of a saved view.
+const savedViewParam = "view"
+
+// maxViewName caps the length of saved view names.
+const maxViewName = 100
+
+// savedView is a saved view with the link that opens it.
+type savedView struct {
+	database.SavedView
+	URL string `json:"url"`
+	// Own is set for the views of the current user, who may share and delete
+	// them
+	Own bool `json:"own"`
+}
+
+// currentUser returns the user saved views belong to: the signed-in user,
+// or the browser session token when authentication is disabled.
+func currentUser(r *http.Request, token string) string {
+	if u := auth.UserFrom(r.Context()); u != nil {
+		return u.Name
+	}
+	return token
+}
+
+func newSavedView(v database.SavedView, user string) savedView {
+	return savedView{SavedView: v, URL: viewURL(&v), Own: v.Owner == user}
+}
+
+// viewURL returns the report page link of a saved view.
+func viewURL(v *database.SavedView) string {
+	params := url.Values{}
+	for key, vals := range v.Filters {
+		params[key] = vals
+	}
+	if len(v.Sort) > 0 {
+		params["sort"] = v.Sort
+	}
+	if v.PageSize > 0 {
+		params.Set("page_size", strconv.Itoa(v.PageSize))
+	}
+	params.Set("id", v.ReportID)
+	params.Set(savedViewParam, strconv.FormatInt(v.ID, 10))
+	return "/report?" + params.Encode()
+}
+
+// cleanView checks a view to save against its report. Paging and drill-down
+// parameters are dropped from the filters, unknown columns from the layout.
+func cleanView(report *config.Report, v *database.SavedView) error {
+	v.Name = strings.TrimSpace(v.Name)
+	if v.Name == "" || len(v.Name) > maxViewName {
+		return errors.New("view name must be 1 to 100 characters")
+	}
+	if _, err := parseSorts(report.Columns, url.Values{"sort": v.Sort}); err != nil {
+		return err
+	}
+	if v.PageSize < 0 {
+		v.PageSize = 0
+	}
+
+	filters := make(map[string][]string)
+	for key, vals := range v.Filters {
+		if navParams[key] || key == "sort" || key == trailParam || isDrillParam(key) {
+			continue
+		}
+		filters[key] = vals
+	}
+	v.Filters = filters
+
+	known := func(names []string) []string {
+		kept := []string{}
+		for _, name := range names {
+			if findColumn(report, name) != nil {
+				kept = append(kept, name)
+			}
+		}
+		return kept
+	}
+	v.Columns = known(v.Columns)
+	v.Hidden = known(v.Hidden)
+	if v.Sort == nil {
+		v.Sort = []string{}
+	}
+	return nil
+}
+
+// viewID parses the viewID path value.
+func viewID(r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("viewID"), 10, 64)
+	return id, err == nil
+}
+
+// viewError writes the JSON error of a saved view operation.
+func viewError(w http.ResponseWriter, err error) {
+	if errors.Is(err, database.ErrViewNotFound) {
+		writeJSONError(w, http.StatusNotFound, "Saved view not found")
+		return
+	}
+	log.Printf("Saved view: %v", err)
+	writeJSONError(w, http.StatusInternalServerError, "Saved views are not available")
+}
+
+// APIViewsHandler lists the saved views of a report the user can load.
+func APIViewsHandler(w http.ResponseWriter, r *http.Request) {
+	report := findReport(r.PathValue("id"))
+	if report == nil {
+		writeJSONError(w, http.StatusNotFound, "Report not found")
+		return
+	}
+	if !canView(r.Context(), report) {
+		writeJSONError(w, http.StatusForbidden, "Access to this report is denied")
+		return
+	}
+	user := currentUser(r, browserSession(w, r))
+	views, err := database.SavedViews(r.Context(), pool.GetDB(), report.ID, user)
+	if err != nil {
+		viewError(w, err)
+		return
+	}
+	out := make([]savedView, 0, len(views))
+	for _, v := range views {
+		out = append(out, newSavedView(v, user))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"views": out})
+}
+
+// APISaveViewHandler saves the posted view of a report under its name,
+// replacing the user's view of the same name.
+func APISaveViewHandler(w http.ResponseWriter, r *http.Request) {
+	report := findReport(r.PathValue("id"))
+	if report == nil {
+		writeJSONError(w, http.StatusNotFound, "Report not found")
+		return
+	}
+	if !canView(r.Context(), report) {
+		writeJSONError(w, http.StatusForbidden, "Access to this report is denied")
+		return
+	}
+	var v database.SavedView
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid view: "+err.Error())
+		return
+	}
+	if err := cleanView(report, &v); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	user := currentUser(r, browserSession(w, r))
+	v.ReportID = report.ID
+	v.Owner = user
+	if err := database.SaveView(r.Context(), pool.GetDB(), &v); err != nil {
+		viewError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newSavedView(v, user))
+}
+
+// APIViewHandler returns a saved view the user owns or that is shared.
+func APIViewHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := viewID(r)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "Saved view not found")
+		return
+	}
+	user := currentUser(r, browserSession(w, r))
+	v, err := database.LoadView(r.Context(), pool.GetDB(), id, user)
+	if err == nil {
+		if report := findReport(v.ReportID); report == nil || !canView(r.Context(), report) {
+			err = database.ErrViewNotFound
+		}
+	}
+	if err != nil {
+		viewError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newSavedView(*v, user))
+}
+
+// APIShareViewHandler shares or unshares one of the user's saved views, as
+// {"shared": true|false}.
+func APIShareViewHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := viewID(r)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "Saved view not found")
+		return
+	}
+	var body struct {
+		Shared bool `json:"shared"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request: "+err.Error())
+		return
+	}
+	user := currentUser(r, browserSession(w, r))
+	if err := database.ShareView(r.Context(), pool.GetDB(), id, user, body.Shared); err != nil {
+		viewError(w, err)
+		return
+	}
+	v, err := database.LoadView(r.Context(), pool.GetDB(), id, user)
+	if err != nil {
+		viewError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newSavedView(*v, user))
+}
+
+// APIDeleteViewHandler deletes one of the user's saved views.
+func APIDeleteViewHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := viewID(r)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "Saved view not found")
+		return
+	}
+	if err := database.DeleteView(r.Context(), pool.GetDB(), id, currentUser(r, browserSession(w, r))); err != nil {
+		viewError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pageViews returns the saved views of report for the selector of the report
+// page, and the one named by the view parameter. Saved views are optional,
+// so errors are only logged.
+func pageViews(ctx context.Context, report *config.Report, user string, params url.Values) ([]savedView, *savedView) {
+	views, err := database.SavedViews(ctx, pool.GetDB(), report.ID, user)
+	if err != nil {
+		log.Printf("Saved views of %s: %v", report.ID, err)
+		return nil, nil
+	}
+	var current *savedView
+	out := make([]savedView, len(views))
+	for i, v := range views {
+		out[i] = newSavedView(v, user)
+		if strconv.FormatInt(v.ID, 10) == params.Get(savedViewParam) {
+			current = &out[i]
+		}
+	}
+	return out, current
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"GoBI/internal/auth"
+	"encoding/hex"
+	"net/http"
+)
+
+// sessionCookie binds the browser to the report cursors it opened.
+const sessionCookie = "gobi_session"
+
+// browserSession returns the session token of the browser, issuing a new
+// cookie if it has none. It is called once per request, as a browser without
+// the cookie gets a new token from every call.
+func browserSession(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(sessionCookie); err == nil && len(c.Value) == 64 {
+		if _, err := hex.DecodeString(c.Value); err == nil {
+			return c.Value
+		}
+	}
+	token := auth.NewToken()
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token
+}
+
+// sessionOwner returns the owner of the report cursors, totals and views of
+// the browser session token. A signed-in user's name is part of it, so they
+// aren't handed on to the next user of the browser.
+func sessionOwner(r *http.Request, token string) string {
+	if u := auth.UserFrom(r.Context()); u != nil {
+		return u.Name + ":" + token
+	}
+	return token
+}
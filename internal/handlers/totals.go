@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"GoBI/internal/config"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// aggregateExpr returns the SQL aggregate for a column's aggregate_func.
+func aggregateExpr(fn, column string) (string, error) {
+	switch fn {
+	case "sum", "min", "max", "count":
+		return fmt.Sprintf("%s(%s)", fn, column), nil
+	case "avg":
+		return fmt.Sprintf("round(avg(%s)::numeric, 2)", column), nil
+	case "count_distinct":
+		return fmt.Sprintf("count(DISTINCT %s)", column), nil
+	}
+	return "", fmt.Errorf("unknown aggregate function %q", fn)
+}
+
+// computeTotals evaluates the declared column aggregates over the whole
+// result of query. It returns nil if the report declares no aggregates.
+func computeTotals(ctx context.Context, report *config.Report, query string, args []interface{}) (map[string]interface{}, error) {
+	var exprs, names []string
+	for _, col := range report.Columns {
+		if col.AggregateFunc == "" {
+			continue
+		}
+		expr, err := aggregateExpr(col.AggregateFunc, col.Name)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", col.Name, err)
+		}
+		exprs = append(exprs, expr)
+		names = append(names, col.Name)
+	}
+	if len(exprs) == 0 {
+		return nil, nil
+	}
+
+	totalsQuery := fmt.Sprintf("SELECT %s FROM (%s) AS t", strings.Join(exprs, ", "), query)
+	values := make([]interface{}, len(exprs))
+	dest := make([]interface{}, len(exprs))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := pool.GetDB().QueryRowContext(ctx, totalsQuery, args...).Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	totals := make(map[string]interface{}, len(names))
+	for i, name := range names {
+		if b, ok := values[i].([]byte); ok {
+			totals[name] = string(b)
+		} else {
+			totals[name] = values[i]
+		}
+	}
+	return totals, nil
+}
@@ -1,7 +1,56 @@
 package handlers
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 type TableColumn struct {
-	Name   string
-	Label  string
-	Hidden bool
+	Name          string
+	Label         string
+	Hidden        bool
+	Sortable      bool
+	AggregateFunc string
+}
+
+// PageInfo is the position of the shown page in a cursor-backed result.
+type PageInfo struct {
+	From     int
+	To       int
+	RowCount int64 // -1 while unknown
+	Page     int
+	Pages    int
+}
+
+func newPageInfo(offset int, rowCount int64, pageSize, shown int) *PageInfo {
+	info := &PageInfo{From: offset + 1, To: offset + shown, RowCount: rowCount, Page: offset/pageSize + 1}
+	if shown == 0 {
+		info.From = offset
+	}
+	if rowCount >= 0 {
+		info.Pages = int((rowCount + int64(pageSize) - 1) / int64(pageSize))
+	}
+	return info
+}
+
+// Label renders the position as "41–60 / 12 345".
+func (p *PageInfo) Label() string {
+	if p.RowCount < 0 {
+		return fmt.Sprintf("%s–%s / ?", groupDigits(int64(p.From)), groupDigits(int64(p.To)))
+	}
+	return fmt.Sprintf("%s–%s / %s", groupDigits(int64(p.From)), groupDigits(int64(p.To)), groupDigits(p.RowCount))
+}
+
+// groupDigits formats n with Hungarian thousands separators.
+func groupDigits(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	var b strings.Builder
+	for i, r := range s {
+		if i > 0 && (len(s)-i)%3 == 0 && s[i-1] != '-' {
+			b.WriteRune(' ')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
 }
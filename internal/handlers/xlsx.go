@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// xlsxMaxRows is the row limit of an Excel worksheet.
+const xlsxMaxRows = 1048576
+
+// xlsxWriter writes an export as an Excel workbook through excelize's stream
+// writer, which spills large sheets to a temporary file instead of memory.
+type xlsxWriter struct {
+	out       io.Writer
+	file      *excelize.File
+	sheet     string
+	sw        *excelize.StreamWriter
+	types     []string
+	row       int
+	boldStyle int
+	dateStyle int
+	// lastDataRow excludes the totals row from the autofilter range
+	lastDataRow int
+}
+
+func newXLSXWriter(out io.Writer, title string) *xlsxWriter {
+	return &xlsxWriter{out: out, file: excelize.NewFile(), sheet: sheetName(title)}
+}
+
+// sheetName makes a report title a valid worksheet name: at most 31
+// characters, none of them special.
+func sheetName(title string) string {
+	var name []rune
+	for _, r := range title {
+		switch r {
+		case ':', '\\', '/', '?', '*', '[', ']':
+			r = '-'
+		}
+		name = append(name, r)
+	}
+	if len(name) > 31 {
+		name = name[:31]
+	}
+	if len(name) == 0 {
+		return "Report"
+	}
+	return string(name)
+}
+
+func (x *xlsxWriter) WriteHeader(labels, types []string) error {
+	var err error
+	if err = x.file.SetSheetName("Sheet1", x.sheet); err != nil {
+		return err
+	}
+	if x.sw, err = x.file.NewStreamWriter(x.sheet); err != nil {
+		return err
+	}
+	if x.boldStyle, err = x.file.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}}); err != nil {
+		return err
+	}
+	dateFormat := "yyyy-mm-dd hh:mm:ss"
+	if x.dateStyle, err = x.file.NewStyle(&excelize.Style{CustomNumFmt: &dateFormat}); err != nil {
+		return err
+	}
+
+	// Panes must be set before the first row is written
+	if err := x.sw.SetPanes(&excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"}); err != nil {
+		return err
+	}
+	if len(labels) > 0 {
+		if err := x.sw.SetColWidth(1, len(labels), 18); err != nil {
+			return err
+		}
+	}
+
+	x.types = types
+	cells := make([]interface{}, len(labels))
+	for i, label := range labels {
+		cells[i] = excelize.Cell{StyleID: x.boldStyle, Value: label}
+	}
+	return x.writeRow(cells)
+}
+
+func (x *xlsxWriter) writeRow(cells []interface{}) error {
+	if x.row >= xlsxMaxRows {
+		return fmt.Errorf("result exceeds the %d row limit of xlsx, export it as csv", xlsxMaxRows)
+	}
+	x.row++
+	cell, err := excelize.CoordinatesToCellName(1, x.row)
+	if err != nil {
+		return err
+	}
+	return x.sw.SetRow(cell, cells)
+}
+
+// typedCell converts a database value to the cell type of its column.
+func (x *xlsxWriter) typedCell(i int, v interface{}, style int) excelize.Cell {
+	colType := ""
+	if i < len(x.types) {
+		colType = x.types[i]
+	}
+	if raw, ok := v.([]byte); ok {
+		v = string(raw)
+	}
+
+	switch val := v.(type) {
+	case nil:
+		return excelize.Cell{StyleID: style}
+	case time.Time:
+		if style == 0 {
+			style = x.dateStyle
+		}
+		return excelize.Cell{StyleID: style, Value: val}
+	case string:
+		switch colType {
+		case "int", "number":
+			if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+				return excelize.Cell{StyleID: style, Value: n}
+			}
+			if f, err := strconv.ParseFloat(val, 64); err == nil {
+				return excelize.Cell{StyleID: style, Value: f}
+			}
+		}
+		return excelize.Cell{StyleID: style, Value: val}
+	case int64, float64, bool:
+		if colType == "string" {
+			return excelize.Cell{StyleID: style, Value: formatValue(val)}
+		}
+		return excelize.Cell{StyleID: style, Value: val}
+	}
+	return excelize.Cell{StyleID: style, Value: formatValue(v)}
+}
+
+func (x *xlsxWriter) WriteRow(values []interface{}) error {
+	cells := make([]interface{}, len(values))
+	for i, v := range values {
+		cells[i] = x.typedCell(i, v, 0)
+	}
+	if err := x.writeRow(cells); err != nil {
+		return err
+	}
+	x.lastDataRow = x.row
+	return nil
+}
+
+func (x *xlsxWriter) WriteTotals(values []interface{}) error {
+	cells := make([]interface{}, len(values))
+	for i, v := range values {
+		cells[i] = x.typedCell(i, v, x.boldStyle)
+	}
+	return x.writeRow(cells)
+}
+
+// Flush is a no-op: the workbook can only be written once it is complete.
+func (x *xlsxWriter) Flush() error {
+	return nil
+}
+
+// Abort removes the temporary files of the workbook.
+func (x *xlsxWriter) Abort() {
+	x.file.Close()
+}
+
+func (x *xlsxWriter) Close() error {
+	defer x.file.Close()
+	if x.sw == nil {
+		return fmt.Errorf("export has no header")
+	}
+
+	if len(x.types) > 0 {
+		lastCol, err := excelize.ColumnNumberToName(len(x.types))
+		if err != nil {
+			return err
+		}
+		lastRow := x.lastDataRow
+		if lastRow < 1 {
+			lastRow = 1
+		}
+		if err := x.file.AutoFilter(x.sheet, fmt.Sprintf("A1:%s%d", lastCol, lastRow), nil); err != nil {
+			return err
+		}
+	}
+	if err := x.sw.Flush(); err != nil {
+		return err
+	}
+	return x.file.Write(x.out)
+}
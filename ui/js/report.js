@@ -190,31 +190,144 @@ function applyViewSettings() {
             $('#dock-sidebar-btn').addClass('active');
         }
 
-        // Apply Order
-        if (settings.columnOrder && settings.columnOrder.length > 0) {
-            const $theadRow = $table.find('thead tr');
-            settings.columnOrder.forEach(field => {
-                const $th = $theadRow.find(`th[data-field="${field}"]`);
-                if ($th.length) $theadRow.append($th);
-            });
-            // Rebuild rows to match
-            $table.find('tbody tr').each(function () {
-                const $tr = $(this);
-                settings.columnOrder.forEach(field => {
-                    const $td = $tr.find(`.col-${field}`);
-                    if ($td.length) $tr.append($td);
-                });
-            });
-        }
+        applyColumnLayout(settings.columnOrder, settings.hiddenColumns);
+    } catch (e) {
+        console.error("Failed to apply settings:", e);
+    }
+}
 
-        // Apply Visibility
-        if (settings.hiddenColumns) {
-            settings.hiddenColumns.forEach(field => {
-                $(`.col-${field}`).addClass('hidden-col');
+function applyColumnLayout(columnOrder, hiddenColumns) {
+    const $table = $('.results-table');
+
+    // Apply Order
+    if (columnOrder && columnOrder.length > 0) {
+        const $theadRow = $table.find('thead tr');
+        columnOrder.forEach(field => {
+            const $th = $theadRow.find(`th[data-field="${field}"]`);
+            if ($th.length) $theadRow.append($th);
+        });
+        // Rebuild rows to match
+        $table.find('tbody tr').each(function () {
+            const $tr = $(this);
+            columnOrder.forEach(field => {
+                const $td = $tr.find(`.col-${field}`);
+                if ($td.length) $tr.append($td);
             });
+        });
+        // Keep the actions column last
+        $table.find('tr').each(function () {
+            $(this).append($(this).children('.col-actions'));
+        });
+    }
+
+    // Apply Visibility
+    if (hiddenColumns) {
+        hiddenColumns.forEach(field => {
+            $(`.col-${field}`).addClass('hidden-col');
+        });
+    }
+}
+
+// --- Saved views ---
+
+// The table carries the query and page size of its last refresh
+function tableState() {
+    const $table = $('.results-table');
+    const query = new URLSearchParams($table.data('query') || '');
+    const filters = {};
+    for (const key of new Set(query.keys())) {
+        if (key !== 'sort') filters[key] = query.getAll(key);
+    }
+    const view = {
+        filters: filters,
+        sort: query.getAll('sort'),
+        columns: [],
+        hidden: [],
+        page_size: parseInt($table.data('page-size'), 10) || 0
+    };
+    $table.find('th[data-field]').each(function () {
+        const field = $(this).data('field');
+        view.columns.push(field);
+        if ($(this).hasClass('hidden-col')) view.hidden.push(field);
+    });
+    return view;
+}
+
+function syncSortFromTable() {
+    const query = new URLSearchParams($('.results-table').data('query') || '');
+    currentSort = query.getAll('sort').map(s => {
+        const [field, dir] = s.split(':');
+        return { field: field, dir: (dir || 'ASC').toUpperCase() };
+    });
+}
+
+function viewRequest(method, url, body) {
+    return fetch(url, {
+        method: method,
+        headers: { 'Content-Type': 'application/json' },
+        body: body ? JSON.stringify(body) : undefined
+    }).then(resp => {
+        if (!resp.ok) {
+            return resp.json().then(err => { throw new Error(err.error); });
         }
-    } catch (e) {
-        console.error("Failed to apply settings:", e);
+        return resp.status === 204 ? null : resp.json();
+    });
+}
+
+function setupSavedViews() {
+    const $views = $('#saved-views');
+    if (!$views.length) return;
+    const reportID = $views.data('report');
+    const $select = $('#saved-view-select');
+    const $current = $select.find('option:selected');
+
+    $select.on('change', function () {
+        const url = $(this).find('option:selected').data('url');
+        window.location = url || `/report?id=${encodeURIComponent(reportID)}`;
+    });
+
+    $('#save-view-btn').on('click', () => {
+        const own = $current.val() && $current.data('own') === true;
+        const name = prompt('Nézet neve:', own ? $current.text().trim() : '');
+        if (!name) return;
+        const view = tableState();
+        view.name = name;
+        view.shared = own && $current.data('shared') === true;
+        viewRequest('POST', `/api/v1/reports/${encodeURIComponent(reportID)}/views`, view)
+            .then(saved => { window.location = saved.url; })
+            .catch(err => alert(err.message));
+    });
+
+    $('#share-view-btn').on('click', () => {
+        const shared = $current.data('shared') !== true;
+        viewRequest('PUT', `/api/v1/views/${$current.val()}/shared`, { shared: shared })
+            .then(view => {
+                if (view.shared && navigator.clipboard) {
+                    navigator.clipboard.writeText(`${window.location.origin}/report?id=${encodeURIComponent(reportID)}&view=${view.id}`);
+                    alert('A nézet linkje a vágólapra került.');
+                }
+                window.location = view.url;
+            })
+            .catch(err => alert(err.message));
+    });
+
+    $('#delete-view-btn').on('click', () => {
+        if (!confirm(`Törli a(z) "${$current.text().trim()}" nézetet?`)) return;
+        viewRequest('DELETE', `/api/v1/views/${$current.val()}`)
+            .then(() => { window.location = `/report?id=${encodeURIComponent(reportID)}`; })
+            .catch(err => alert(err.message));
+    });
+
+    // An opened view brings its column layout
+    if ($current.val()) {
+        viewRequest('GET', `/api/v1/views/${$current.val()}`)
+            .then(view => {
+                $('.results-table th[data-field], .results-table td').removeClass('hidden-col');
+                applyColumnLayout(view.columns, view.hidden);
+                rebuildColumnChooser();
+                saveViewSettings();
+            })
+            .catch(err => console.error("Failed to load view:", err));
     }
 }
 
@@ -273,14 +386,32 @@ function showRowDetails(tr) {
     $('#detail-sidebar, #sidebar-overlay').addClass('active');
 }
 
+// --- Query cancellation ---
+
+function setupCancel() {
+    const $btn = $('#cancel-query-btn');
+    if (!$btn.length) return;
+
+    $('.param-form').on('submit', () => $btn.removeClass('hidden'));
+    document.body.addEventListener('htmx:beforeRequest', () => $btn.removeClass('hidden'));
+    document.body.addEventListener('htmx:afterRequest', () => $btn.addClass('hidden'));
+
+    $btn.on('click', () => {
+        fetch(`/report/cancel?session=${encodeURIComponent($btn.data('session'))}`, { method: 'POST' });
+    });
+}
+
 // --- Init ---
 
 $(document).ready(() => {
     setupColumnChooser();
     setupDragAndDrop();
     setupSidebar();
+    setupCancel();
+    setupSavedViews();
 
     // Initial setup
+    syncSortFromTable();
     rebuildColumnChooser();
     updateSortIcons();
     applyViewSettings();
@@ -288,6 +419,7 @@ $(document).ready(() => {
 
     document.body.addEventListener('htmx:afterSwap', (evt) => {
         if (evt.target.id === 'results-table-container') {
+            syncSortFromTable();
             rebuildColumnChooser();
             updateSortIcons();
             applyViewSettings();
@@ -295,7 +427,12 @@ $(document).ready(() => {
         }
     });
 
-    $(document).off('click', '.results-table th[data-field]').on('click', '.results-table th[data-field]', function (e) {
+    // Pager requests fail when the cursor expired or the pool is full
+    document.body.addEventListener('htmx:responseError', (evt) => {
+        alert(evt.detail.xhr.responseText);
+    });
+
+    $(document).off('click', '.results-table th.sortable').on('click', '.results-table th.sortable', function (e) {
         if ($(e.target).hasClass('resizer')) return;
         handleSortClick(e, $(this));
     });
@@ -0,0 +1,13 @@
+SELECT felelos_felhasznalo,
+       adattisztitas_allapota,
+       sum(darab) AS darab
+  FROM vir.vir_vir10
+ WHERE 1 = 1
+--<from
+   AND letda >= :from
+-->
+--<to
+   AND letda < :to
+-->
+   AND felelos_felhasznalo = :felelos -- #felelos
+ GROUP BY felelos_felhasznalo, adattisztitas_allapota